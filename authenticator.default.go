@@ -0,0 +1,101 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+)
+
+// ======== ======== Token authenticator ======== ========
+// TokenAuthenticator client send one token packet, server validate it.
+// - Username/password can be carried in the token, in any format the Validate func understands.
+type TokenAuthenticator struct {
+	Validate func(token string) (identity interface{}, err error)
+}
+
+func (t TokenAuthenticator) Authenticate(_ context.Context, hs *Handshake) (interface{}, error) {
+	token, err := hs.Receive()
+	if err != nil {
+		return nil, err
+	}
+	return t.Validate(string(token))
+}
+
+// TokenCredential the client side of TokenAuthenticator
+type TokenCredential struct {
+	Token string
+}
+
+func (t TokenCredential) Handshake(_ context.Context, hs *ClientHandshake) error {
+	return hs.Send([]byte(t.Token))
+}
+
+// ======== ======== HMAC challenge-response authenticator ======== ========
+const hmacChallengeLen = 32
+
+// HMACAuthenticator server send a random challenge, client answer HMAC-SHA256(secret, challenge).
+// - The answer packet: [keyID len 8bit][keyID][mac]. The keyID is used as the session identity.
+type HMACAuthenticator struct {
+	Secret func(keyID string) ([]byte, error)
+}
+
+func (h HMACAuthenticator) Authenticate(_ context.Context, hs *Handshake) (interface{}, error) {
+	challenge := make([]byte, hmacChallengeLen)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+	if err := hs.Send(challenge); err != nil {
+		return nil, err
+	}
+
+	answer, err := hs.Receive()
+	if err != nil {
+		return nil, err
+	}
+	if len(answer) < 1 || len(answer) != 1+int(answer[0])+sha256.Size {
+		return nil, errors.New("HMAC answer malformed. ")
+	}
+
+	keyID := string(answer[1 : 1+answer[0]])
+	secret, err := h.Secret(keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(challenge)
+	if !hmac.Equal(mac.Sum(nil), answer[1+answer[0]:]) {
+		return nil, errors.New("HMAC mismatch. ")
+	}
+
+	return keyID, nil
+}
+
+// HMACCredential the client side of HMACAuthenticator
+type HMACCredential struct {
+	KeyID  string
+	Secret []byte
+}
+
+func (h HMACCredential) Handshake(_ context.Context, hs *ClientHandshake) error {
+	if len(h.KeyID) > 255 {
+		return errors.New("HMAC keyID too long. ")
+	}
+
+	challenge, err := hs.Receive()
+	if err != nil {
+		return err
+	}
+
+	mac := hmac.New(sha256.New, h.Secret)
+	mac.Write(challenge)
+
+	answer := append([]byte{byte(len(h.KeyID))}, h.KeyID...)
+	return hs.Send(mac.Sum(answer))
+}
@@ -0,0 +1,157 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"context"
+	"errors"
+	"hash/adler32"
+	"time"
+)
+
+// Handshake result, the first byte of the last handshake packet sent by server.
+const (
+	handshakeAccepted byte = 0
+	handshakeRejected byte = 1
+)
+
+// Authenticator authenticate a new connection before the session created.
+// - Runs before SessionListener.OnSessionCreate, no message is delivered until it accepted.
+// - Return a nil error to accept the connection, the identity will be attached to the session.
+//   A string identity is also bound as the userID of the session, see TCPServer.BindUser
+// - Return an error to reject the connection, the error text is sent to the client as the reason.
+type Authenticator interface {
+	Authenticate(ctx context.Context, hs *Handshake) (identity interface{}, err error)
+}
+
+// ClientCredentialProvider the client side of Authenticator.
+// - Exchange the handshake packets the server Authenticator expected.
+type ClientCredentialProvider interface {
+	Handshake(ctx context.Context, hs *ClientHandshake) error
+}
+
+// AuthRejectedError is returned by Dial when the server rejected the handshake.
+type AuthRejectedError struct {
+	Reason string
+}
+
+func (e *AuthRejectedError) Error() string { return "Handshake rejected. " + e.Reason }
+
+// Handshake server side handshake packet exchanger. Packet bodies are raw bytes, not through Codec.
+type Handshake struct {
+	session *Session
+}
+
+// Session return the session being authenticated. (Not registered to server yet)
+func (h *Handshake) Session() *Session {
+	return h.session
+}
+
+// RemoteAddr return the client address
+func (h *Handshake) RemoteAddr() string {
+	return h.session.RemoteAddr()
+}
+
+// Send send a handshake packet to client
+func (h *Handshake) Send(data []byte) error {
+	return writePacket(h.session.conn, NewPacket(PacketVersion, uint32(len(data)), data, adler32.Checksum(data)))
+}
+
+// Receive receive a handshake packet from client
+func (h *Handshake) Receive() ([]byte, error) {
+	pac, err := readPacket(h.session.conn, h.session.serRef.maxPacketBodyLen)
+	if err != nil {
+		return nil, err
+	}
+	return pac.body, nil
+}
+
+// ClientHandshake client side handshake packet exchanger. Packet bodies are raw bytes, not through Codec.
+type ClientHandshake struct {
+	cli *TCPClient
+}
+
+// Client return the client being authenticated
+func (h *ClientHandshake) Client() *TCPClient {
+	return h.cli
+}
+
+// Send send a handshake packet to server
+func (h *ClientHandshake) Send(data []byte) error {
+	return writePacket(h.cli.connect, NewPacket(PacketVersion, uint32(len(data)), data, adler32.Checksum(data)))
+}
+
+// Receive receive a handshake packet from server
+// - If the server closed the connection (e.g. over limit), the error is the CloseFrame.
+func (h *ClientHandshake) Receive() ([]byte, error) {
+	pac, err := readPacket(h.cli.connect, h.cli.maxPacketBodyLen)
+	if err != nil {
+		return nil, err
+	}
+	if frame, ok := closeFrameOf(pac); ok {
+		return nil, frame
+	}
+	return pac.body, nil
+}
+
+// serverHandshake run the server authenticator on the session, and send the result to client.
+func serverHandshake(ctx context.Context, s *Session, tcpSer *TCPServer) error {
+	if err := s.conn.SetDeadline(time.Now().Add(tcpSer.handshakeTimeout)); err != nil {
+		return err
+	}
+
+	hs := &Handshake{session: s}
+
+	var identity interface{}
+	var authErr error
+	if !tcpSer.safeCall(s, "Authenticator.Authenticate", func() { identity, authErr = tcpSer.authenticator.Authenticate(ctx, hs) }) {
+		authErr = errors.New("Authenticator panic. ")
+	}
+
+	result := []byte{handshakeAccepted}
+	if authErr != nil {
+		result = append([]byte{handshakeRejected}, authErr.Error()...)
+	}
+	if err := hs.Send(result); err != nil {
+		return err
+	}
+	if authErr != nil {
+		return authErr
+	}
+
+	s.identity = identity
+
+	// Clear handshake deadline, the read/write loop set their own.
+	return s.conn.SetDeadline(time.Time{})
+}
+
+// clientHandshake run the client credential provider, and read the result from server.
+func clientHandshake(ctx context.Context, cli *TCPClient) error {
+	deadline := time.Now().Add(cli.handshakeTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err := cli.connect.SetDeadline(deadline); err != nil {
+		return err
+	}
+
+	hs := &ClientHandshake{cli: cli}
+	if err := cli.credentialProvider.Handshake(ctx, hs); err != nil {
+		return err
+	}
+
+	result, err := hs.Receive()
+	if err != nil {
+		return err
+	}
+	if len(result) == 0 {
+		return errors.New("Handshake result empty. ")
+	}
+	if result[0] != handshakeAccepted {
+		return &AuthRejectedError{Reason: string(result[1:])}
+	}
+
+	return cli.connect.SetDeadline(time.Time{})
+}
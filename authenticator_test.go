@@ -0,0 +1,113 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type testIdentitySessionListener struct {
+	created chan interface{}
+}
+
+func (t testIdentitySessionListener) OnSessionCreate(s *Session) {
+	t.created <- s.Identity()
+}
+
+func (t testIdentitySessionListener) OnSessionClose(_ *Session) {}
+
+func TestTokenAuthenticator(t *testing.T) {
+	created := make(chan interface{}, 2)
+
+	server, err := NewTCPServer("127.0.0.1:18826").
+		RegisterMessageListener(&TestExampleServerMessageListener{}).
+		RegisterSessionListener(testIdentitySessionListener{created: created}).
+		SetAuthenticator(TokenAuthenticator{Validate: func(token string) (interface{}, error) {
+			if token != "secret-token" {
+				return nil, errors.New("bad token")
+			}
+			return "user-42", nil
+		}}).
+		SetDebugMode(false).
+		Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	// Wrong token rejected, no session created.
+	_, err = NewTcpClient("127.0.0.1:18826").
+		RegisterMessageListener(&TestExampleClientListener{}).
+		SetCredentialProvider(TokenCredential{Token: "wrong"}).
+		SetDebugMode(false).
+		Dial()
+	var rejected *AuthRejectedError
+	if !errors.As(err, &rejected) || rejected.Reason != "bad token" {
+		t.Fatalf("expect AuthRejectedError(bad token), got %v", err)
+	}
+
+	// Right token accepted, identity attached.
+	client, err := NewTcpClient("127.0.0.1:18826").
+		RegisterMessageListener(&TestExampleClientListener{}).
+		SetCredentialProvider(TokenCredential{Token: "secret-token"}).
+		SetDebugMode(false).
+		Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Hangup("Test done.")
+
+	select {
+	case identity := <-created:
+		if identity != "user-42" {
+			t.Fatalf("expect identity user-42, got %v", identity)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("session not created")
+	}
+}
+
+func TestHMACAuthenticator(t *testing.T) {
+	created := make(chan interface{}, 1)
+	secret := []byte("hmac-secret")
+
+	server, err := NewTCPServer("127.0.0.1:18827").
+		RegisterMessageListener(&TestExampleServerMessageListener{}).
+		RegisterSessionListener(testIdentitySessionListener{created: created}).
+		SetAuthenticator(HMACAuthenticator{Secret: func(keyID string) ([]byte, error) {
+			return secret, nil
+		}}).
+		SetDebugMode(false).
+		Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	_, err = NewTcpClient("127.0.0.1:18827").
+		RegisterMessageListener(&TestExampleClientListener{}).
+		SetCredentialProvider(HMACCredential{KeyID: "device-1", Secret: []byte("wrong")}).
+		SetDebugMode(false).
+		Dial()
+	if err == nil {
+		t.Fatal("expect wrong secret rejected")
+	}
+
+	client, err := NewTcpClient("127.0.0.1:18827").
+		RegisterMessageListener(&TestExampleClientListener{}).
+		SetCredentialProvider(HMACCredential{KeyID: "device-1", Secret: secret}).
+		SetDebugMode(false).
+		Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Hangup("Test done.")
+
+	if identity := <-created; identity != "device-1" {
+		t.Fatalf("expect identity device-1, got %v", identity)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+)
+
+// DialErrorKind why the dial failed
+type DialErrorKind int
+
+const (
+	DialErrorOther     DialErrorKind = iota // Other error, see DialError.Err
+	DialErrorDNS                            // The server host can't be resolved
+	DialErrorRefused                        // The server refused the connection, no one listening
+	DialErrorTimeout                        // The connect timeout or the dial ctx deadline exceeded
+	DialErrorCanceled                       // The dial ctx canceled
+	DialErrorLocalAddr                      // The local address set by SetLocalAddr is invalid, not dialed
+)
+
+var dialErrorKindNames = [...]string{"Other", "DNS", "Refused", "Timeout", "Canceled", "LocalAddr"}
+
+func (k DialErrorKind) String() string {
+	if int(k) < len(dialErrorKindNames) {
+		return dialErrorKindNames[k]
+	}
+	return "Unknown"
+}
+
+// DialError is returned by Dial when connect to the server failed.
+// - Use errors.As to get it, and the Kind to tell DNS failures from refused connections and timeouts.
+type DialError struct {
+	Kind DialErrorKind
+	Addr string
+	Err  error
+}
+
+func (e *DialError) Error() string {
+	return "Dial " + e.Addr + " failure(" + e.Kind.String() + "). " + e.Err.Error()
+}
+
+func (e *DialError) Unwrap() error { return e.Err }
+
+func newDialError(addr string, err error) *DialError {
+	kind := DialErrorOther
+
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		kind = DialErrorCanceled
+	case errors.As(err, &dnsErr):
+		kind = DialErrorDNS
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		kind = DialErrorTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		kind = DialErrorRefused
+	}
+
+	return &DialError{Kind: kind, Addr: addr, Err: err}
+}
+
+// newDialer copy the custom dialer, and apply the dial settings of client.
+func (cli *TCPClient) newDialer() (*net.Dialer, error) {
+	dialer := &net.Dialer{}
+	if cli.dialer != nil {
+		*dialer = *cli.dialer
+	}
+
+	if cli.dialTimeout > 0 {
+		dialer.Timeout = cli.dialTimeout
+	}
+	if cli.keepAlive != 0 {
+		dialer.KeepAlive = cli.keepAlive
+	}
+	if cli.localAddr != "" {
+		localAddr, err := net.ResolveTCPAddr("tcp", cli.localAddr)
+		if err != nil {
+			return nil, &DialError{Kind: DialErrorLocalAddr, Addr: cli.serverAddr, Err: err}
+		}
+		dialer.LocalAddr = localAddr
+	}
+
+	return dialer, nil
+}
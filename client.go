@@ -14,29 +14,52 @@ import (
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // TCPClient the tcp server struct
 type TCPClient struct {
-	name             string              // Client Name
-	env              string              // Client Run environment DEBUG|RELEASE
-	status           string              // Client status Preparing|Running|Stop
-	readDeadline     time.Duration       // Client read deadline
-	writeDeadline    time.Duration       // Client write deadline
-	heartbeat        time.Duration       // Client healthy check heartbeat time
-	connect          *net.TCPConn        // Client TCP conn
-	serverAddr       string              // Client connect server address ("tcp", "golang.org:http"| "tcp", "198.51.100.1:80 | [fe80::1%lo0]:53)
-	maxPacketBodyLen uint32              // Client send/receive packet max body length limit (byte)
-	debugLogger      DebugLogger         // Client debug logger
-	logger           Logger              // Client run logger
-	codec            ClientCodec         // Client send/receive packet codec
-	packetHandler    ClientPacketHandler // Client connect on packet receive handler
-	messageListener  ClientMessageListener
-	hangupSign       chan bool
-	msgSendChan      chan interface{}
-	mu               sync.Mutex
-	lastActive       time.Time
+	lastActive         int64               // unix nano, atomic. First for 64-bit alignment on 32-bit arch.
+	name               string              // Client Name
+	env                string              // Client Run environment DEBUG|RELEASE
+	status             string              // Client status Preparing|Running|Stop
+	readDeadline       time.Duration       // Client read deadline
+	writeDeadline      time.Duration       // Client write deadline
+	heartbeat          time.Duration       // Client healthy check heartbeat time
+	connect            *net.TCPConn        // Client TCP conn
+	serverAddr         string              // Client connect server address ("tcp", "golang.org:http"| "tcp", "198.51.100.1:80 | [fe80::1%lo0]:53)
+	maxPacketBodyLen   uint32              // Client send/receive packet max body length limit (byte)
+	debugLogger        DebugLogger         // Client debug logger
+	logger             Logger              // Client run logger
+	codec              ClientCodec         // Client send/receive packet codec
+	packetHandler      ClientPacketHandler // Client connect on packet receive handler
+	messageListener    ClientMessageListener
+	connListener       ClientConnectionListener // Client listening connected, disconnected and reconnecting
+	reconnectInterval  time.Duration            // Client first auto reconnect interval, doubled on each failure (0: disable)
+	reconnectAttempts  int                      // Client max auto reconnect attempts (0: unlimited)
+	credentialProvider ClientCredentialProvider // Client handshake credential (nil: no handshake)
+	handshakeTimeout   time.Duration            // Client handshake timeout
+	dialer             *net.Dialer              // Client custom dialer (nil: default dialer)
+	dialTimeout        time.Duration            // Client connect timeout (0: no timeout, other than the OS)
+	localAddr          string                   // Client local address to bind ("": any)
+	keepAlive          time.Duration            // Client TCP keepalive period (0: default, negative: disable)
+	hangupSign         chan bool
+	msgSendChan        chan interface{}
+	ctrlSendChan       chan *Packet
+	connDone           <-chan struct{} // Client closed when the current connection closed
+	subscriptions      map[string]bool // Client topic patterns subscribed, re-subscribe on dial
+	closeFrame         CloseFrame      // Client the code and reason of the last close
+	hungUp             bool            // Client hangup by application, stop auto reconnect
+	offlineQueue       OfflineQueue    // Client queue messages while disconnected (nil: SendMessage error)
+	offlineTTL         time.Duration   // Client default TTL of the queued messages (0: never expire)
+	flushing           bool            // Client flushing the offline queue, new messages keep queuing
+	rtt                rttTracker      // Client RTT measured by heartbeat
+	maxMissed          int             // Client max heartbeat pings without pong (0: disable)
+	loops              sync.WaitGroup  // Client read/write loops of the current connection
+	heartbeatListener  ClientHeartbeatListener
+	controlHandlers    map[byte]ClientControlHandler
+	mu                 sync.Mutex
 }
 
 // NewTcpClient create a new tcp server
@@ -44,23 +67,37 @@ type TCPClient struct {
 // *    TODO: write usage
 func NewTcpClient(serAddr string) *TCPClient {
 	return &TCPClient{
-		name:             uuid.Must(uuid.NewV4()).String(),
-		env:              DEBUG,
-		status:           Preparing, // Preparing, Running, Stop
-		writeDeadline:    sessionDefaultWriteDeadline,
-		readDeadline:     sessionDefaultReadDeadline,
-		heartbeat:        sessionDefaultHeartbeat,
-		connect:          nil,
-		serverAddr:       serAddr,
-		maxPacketBodyLen: defaultMaxPacketBodyLength,
-		debugLogger:      DebugLogger{isDebugMode: true, logger: DefaultDebugLogger},
-		logger:           DefaultLogger,
-		codec:            ClientDefaultCodec{},
-		packetHandler:    defaultClientPacketHander{},
-		messageListener:  nil,
-		hangupSign:       make(chan bool),
-		msgSendChan:      make(chan interface{}, 8),
-		lastActive:       time.Now(),
+		name:               uuid.Must(uuid.NewV4()).String(),
+		env:                DEBUG,
+		status:             Preparing, // Preparing, Running, Stop
+		writeDeadline:      sessionDefaultWriteDeadline,
+		readDeadline:       sessionDefaultReadDeadline,
+		heartbeat:          sessionDefaultHeartbeat,
+		connect:            nil,
+		serverAddr:         serAddr,
+		maxPacketBodyLen:   defaultMaxPacketBodyLength,
+		debugLogger:        DebugLogger{isDebugMode: true, logger: DefaultDebugLogger},
+		logger:             DefaultLogger,
+		codec:              ClientDefaultCodec{},
+		packetHandler:      defaultClientPacketHander{},
+		messageListener:    nil,
+		connListener:       nil,
+		reconnectInterval:  0,
+		reconnectAttempts:  0,
+		maxMissed:          defaultMaxMissedPongs,
+		heartbeatListener:  nil,
+		controlHandlers:    make(map[byte]ClientControlHandler),
+		credentialProvider: nil,
+		handshakeTimeout:   defaultHandshakeTimeout,
+		dialer:             nil,
+		dialTimeout:        0,
+		localAddr:          "",
+		keepAlive:          0,
+		hangupSign:         make(chan bool),
+		msgSendChan:        make(chan interface{}, 8),
+		ctrlSendChan:       make(chan *Packet, 8),
+		subscriptions:      make(map[string]bool),
+		lastActive:         time.Now().UnixNano(),
 	}
 }
 
@@ -70,6 +107,96 @@ func (cli *TCPClient) RegisterMessageListener(listener ClientMessageListener) *T
 	return cli
 }
 
+// RegisterConnectionListener listening the connection connected, disconnected and reconnecting.
+func (cli *TCPClient) RegisterConnectionListener(listener ClientConnectionListener) *TCPClient {
+	cli.checkPreparingStatus()
+	cli.connListener = listener
+	return cli
+}
+
+// SetAutoReconnect redial after the connection dropped. interval 0 disable. maxAttempts 0 unlimited.
+// - The interval doubled after each failed attempt, up to clientMaxReconnectInterval.
+// - Not reconnect after Hangup, or closed by the server with CloseKicked/ClosePolicyViolation, or auth rejected.
+func (cli *TCPClient) SetAutoReconnect(interval time.Duration, maxAttempts int) *TCPClient {
+	cli.checkPreparingStatus()
+	cli.reconnectInterval = interval
+	cli.reconnectAttempts = maxAttempts
+	return cli
+}
+
+// SetCredentialProvider answer the server Authenticator handshake on dial.
+func (cli *TCPClient) SetCredentialProvider(provider ClientCredentialProvider) *TCPClient {
+	cli.checkPreparingStatus()
+	cli.credentialProvider = provider
+	return cli
+}
+
+// SetHandshakeTimeout the whole handshake must finish in this time, or dial failure.
+func (cli *TCPClient) SetHandshakeTimeout(timeout time.Duration) *TCPClient {
+	cli.checkPreparingStatus()
+	cli.handshakeTimeout = timeout
+	return cli
+}
+
+// SetMaxMissedPongs disconnect with CloseHeartbeatTimeout if the heartbeat pings sent without pong exceed max.
+// - Default 0, disabled. Auto reconnect if enabled.
+func (cli *TCPClient) SetMaxMissedPongs(max int) *TCPClient {
+	cli.checkPreparingStatus()
+	cli.maxMissed = max
+	return cli
+}
+
+// RegisterHeartbeatListener listening the heartbeat ping sent, pong received and timeout.
+func (cli *TCPClient) RegisterHeartbeatListener(listener ClientHeartbeatListener) *TCPClient {
+	cli.checkPreparingStatus()
+	cli.heartbeatListener = listener
+	return cli
+}
+
+func (cli *TCPClient) notifyHeartbeat(event HeartbeatEvent) {
+	if cli.heartbeatListener != nil {
+		cli.heartbeatListener.OnHeartbeat(cli, event)
+	}
+}
+
+// SetOfflineQueue queue the messages sent while disconnected, flushed in order once Dial or reconnect succeeded.
+// - ttl the default TTL of queued messages, 0 never expire. see SendMessageWithTTL
+// - e.g. NewMemoryOfflineQueue(1024), NewFileOfflineQueue("client.journal", 1024)
+func (cli *TCPClient) SetOfflineQueue(queue OfflineQueue, ttl time.Duration) *TCPClient {
+	cli.checkPreparingStatus()
+	cli.offlineQueue = queue
+	cli.offlineTTL = ttl
+	return cli
+}
+
+// SetDialer dial over the custom dialer. The timeout/local address/keepalive set on client override it.
+func (cli *TCPClient) SetDialer(dialer *net.Dialer) *TCPClient {
+	cli.checkPreparingStatus()
+	cli.dialer = dialer
+	return cli
+}
+
+// SetDialTimeout the connect must finish in this time, or dial failure with DialErrorTimeout. 0 no timeout.
+func (cli *TCPClient) SetDialTimeout(timeout time.Duration) *TCPClient {
+	cli.checkPreparingStatus()
+	cli.dialTimeout = timeout
+	return cli
+}
+
+// SetLocalAddr bind the local address on dial. e.g. "192.0.2.1:0"
+func (cli *TCPClient) SetLocalAddr(addr string) *TCPClient {
+	cli.checkPreparingStatus()
+	cli.localAddr = addr
+	return cli
+}
+
+// SetKeepAlive the TCP keepalive period. 0 the OS default, negative disable.
+func (cli *TCPClient) SetKeepAlive(period time.Duration) *TCPClient {
+	cli.checkPreparingStatus()
+	cli.keepAlive = period
+	return cli
+}
+
 func (cli *TCPClient) SetDebugMode(on bool) *TCPClient {
 	cli.mu.Lock()
 
@@ -122,6 +249,32 @@ func (cli *TCPClient) SetSessionWriteDeadline(write time.Duration) *TCPClient {
 	return cli
 }
 
+// Status return the client status Preparing|Running|Stop
+func (cli *TCPClient) Status() string {
+	cli.mu.Lock()
+	defer cli.mu.Unlock()
+
+	return cli.status
+}
+
+// RTT return the round-trip time measured by the heartbeat ping/pong
+func (cli *TCPClient) RTT() RTTStats {
+	return cli.rtt.Stats()
+}
+
+// Ping send a ping and wait the pong, return the round-trip time.
+func (cli *TCPClient) Ping(ctx context.Context) (time.Duration, error) {
+	if status := cli.Status(); status != Running {
+		return 0, errors.New("Client " + status)
+	}
+	return cli.rtt.ping(ctx, cli.ctrlSendChan)
+}
+
+// Pending return the count of messages waiting to be sent
+func (cli *TCPClient) Pending() int {
+	return len(cli.msgSendChan)
+}
+
 func (cli *TCPClient) RemoteAddr() string {
 	return cli.connect.RemoteAddr().String()
 }
@@ -139,53 +292,117 @@ func (cli *TCPClient) checkMessageListenerRegistered() {
 }
 
 func (cli *TCPClient) Dial() (*TCPClient, error) {
+	return cli.DialContext(context.Background())
+}
+
+// DialContext dial the server, the dial and handshake canceled if ctx done.
+// - ctx only bounds the dial, the connection is not closed when ctx done after dialed.
+// - Connect failure returned as *DialError.
+func (cli *TCPClient) DialContext(dialCtx context.Context) (*TCPClient, error) {
 	// Message listener registered or panic
 	//cli.checkMessageListenerRegistered()
 
-	var tcpAddr *net.TCPAddr
-	var err error
+	cli.mu.Lock()
+	cli.hungUp = false
+	cli.mu.Unlock()
+
+	return cli.dial(dialCtx)
+}
 
-	tcpAddr, err = net.ResolveTCPAddr("tcp", cli.serverAddr)
+func (cli *TCPClient) dial(dialCtx context.Context) (*TCPClient, error) {
+	// Wait the loops of the last connection exit
+	cli.loops.Wait()
+
+	dialer, err := cli.newDialer()
 	if err != nil {
 		return nil, err
 	}
 
-	cli.connect, err = net.DialTCP("tcp", nil, tcpAddr)
+	netConn, err := dialer.DialContext(dialCtx, "tcp", cli.serverAddr)
 	if err != nil {
-		return nil, err
+		return nil, newDialError(cli.serverAddr, err)
 	}
+	cli.connect = netConn.(*net.TCPConn)
 
 	ctx, cancel := context.WithCancel(context.Background())
 
+	if cli.credentialProvider != nil {
+		if err = clientHandshake(dialCtx, cli); err != nil {
+			cancel()
+			_ = cli.connect.Close()
+			return nil, err
+		}
+	}
+
 	cli.mu.Lock()
+	if cli.hungUp { // Hangup while reconnecting
+		cli.mu.Unlock()
+		cancel()
+		_ = cli.connect.Close()
+		return nil, errors.New("Client hangup. ")
+	}
 	cli.status = Running
+	cli.connDone = ctx.Done()
+	cli.flushing = cli.offlineQueue != nil && cli.offlineQueue.Len() > 0
+	cli.rtt.resetMissed()
+	flushing := cli.flushing
+	subscriptions := make([]string, 0, len(cli.subscriptions))
+	for pattern := range cli.subscriptions {
+		subscriptions = append(subscriptions, pattern)
+	}
 	cli.mu.Unlock()
 
 	// Handle connect
-	go cli.handleConnect(ctx)
+	cli.handleConnect(ctx)
+
+	// Re-subscribe topics
+	for _, pattern := range subscriptions {
+		cli.queueControl(NewControlPacket(ControlCmdSubscribe, []byte(pattern)), ctx.Done())
+	}
+
+	// Flush messages queued while disconnected
+	if flushing {
+		go cli.flushOffline(ctx)
+	}
 
 	cli.logger.Printf("TCPClient dialed %s.", cli.connect.RemoteAddr().String())
 
 	// Stop holding
+	conn := cli.connect
 	go func() {
 		<-cli.hangupSign
 		cancel()
 
-		err := cli.connect.Close()
+		err := conn.Close()
 		if err != nil {
 			cli.logger.Print("Close connect error.", err)
 		}
 
-		cli.logger.Printf("TCPClient %s hangup %s.", cli.name, cli.connect.RemoteAddr().String())
+		cli.logger.Printf("TCPClient %s hangup %s.", cli.name, conn.RemoteAddr().String())
 	}()
 
+	if cli.connListener != nil {
+		cli.connListener.OnConnected(cli)
+	}
+
 	return cli, nil
 }
 
+// SendMessage send the message to server.
+// - If disconnected, the message is queued when the offline queue set, or error returned.
 func (cli *TCPClient) SendMessage(msg interface{}) error {
+	return cli.SendMessageWithTTL(msg, cli.offlineTTL)
+}
+
+// SendMessageWithTTL send the message to server, if disconnected queue it expired after ttl. ttl 0 never expire.
+func (cli *TCPClient) SendMessageWithTTL(msg interface{}, ttl time.Duration) error {
 	cli.mu.Lock()
 	defer cli.mu.Unlock()
 
+	if cli.offlineQueue != nil && (cli.status != Running || cli.flushing) {
+		return cli.enqueueOffline(msg, ttl)
+	}
+
 	if cli.status != Running { // If status != Running, try to redial.
 		return errors.New("Client " + cli.status)
 	}
@@ -195,37 +412,206 @@ func (cli *TCPClient) SendMessage(msg interface{}) error {
 	return nil
 }
 
+// trySendMessage queue the message without blocking. Error if not running or the send queue full, never queued offline.
+func (cli *TCPClient) trySendMessage(msg interface{}) error {
+	cli.mu.Lock()
+	defer cli.mu.Unlock()
+
+	if cli.status != Running {
+		return errors.New("Client " + cli.status)
+	}
+
+	select {
+	case cli.msgSendChan <- msg:
+		return nil
+	default:
+		return errors.New("Client send queue full. ")
+	}
+}
+
+// Subscribe subscribe the topic pattern, the server publish messages of matched topics to this client.
+// - '.' separated tokens, '*' match one token, '>' match one or more tail tokens. e.g. "chat.*", "news.>"
+// - Subscribed before dial or while disconnected, it will be sent on dial.
+func (cli *TCPClient) Subscribe(pattern string) error {
+	if err := validTopicPattern(pattern); err != nil {
+		return err
+	}
+
+	cli.mu.Lock()
+	cli.subscriptions[pattern] = true
+	running, done := cli.status == Running, cli.connDone
+	cli.mu.Unlock()
+
+	if running { // Closed meanwhile, sent again on the redial
+		cli.queueControl(NewControlPacket(ControlCmdSubscribe, []byte(pattern)), done)
+	}
+
+	return nil
+}
+
+// Unsubscribe unsubscribe the topic pattern
+func (cli *TCPClient) Unsubscribe(pattern string) error {
+	cli.mu.Lock()
+	delete(cli.subscriptions, pattern)
+	running, done := cli.status == Running, cli.connDone
+	cli.mu.Unlock()
+
+	if running { // Closed meanwhile, not subscribed again on the redial
+		cli.queueControl(NewControlPacket(ControlCmdUnsubscribe, []byte(pattern)), done)
+	}
+
+	return nil
+}
+
+// queueControl queue the control packet to the connection, without holding cli.mu.
+// - Return false if the connection closed before queued. done is the connDone of that connection.
+func (cli *TCPClient) queueControl(pac *Packet, done <-chan struct{}) bool {
+	select {
+	case cli.ctrlSendChan <- pac:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// Hangup close the connection with CloseNormal, the server receive the reason. Stop auto reconnect.
 func (cli *TCPClient) Hangup(reason string) {
+	cli.HangupWithCode(CloseNormal, reason)
+}
+
+// HangupWithCode close the connection, the server receive the code and reason. Stop auto reconnect.
+func (cli *TCPClient) HangupWithCode(code CloseCode, reason string) {
+	cli.mu.Lock()
+	cli.hungUp = true
+	cli.mu.Unlock()
+
+	cli.closeConn(CloseFrame{Code: code, Reason: reason}, code != CloseAbnormal, nil)
+}
 
+// disconnect close the connection on the read/write loop error. Auto reconnect if enabled.
+func (cli *TCPClient) disconnect(code CloseCode, reason string, err error) {
+	if err == nil {
+		err = errors.New(reason)
+	}
+	cli.closeConn(CloseFrame{Code: code, Reason: reason}, code != CloseAbnormal, err)
+}
+
+// CloseFrame return the code and reason of the last close. Zero if never closed.
+// - Closed by the server, it is the frame the server sent. Connection dropped, the code is CloseAbnormal.
+func (cli *TCPClient) CloseFrame() CloseFrame {
 	cli.mu.Lock()
 	defer cli.mu.Unlock()
 
-	if cli.status != Stop {
-		cli.status = Stop
+	return cli.closeFrame
+}
+
+// closeConn close the connection and notify the listener, then auto reconnect if need.
+// - sendClose false if the connection is broken or the server closed it.
+// - err the local error, nil if closed by Hangup or the server.
+func (cli *TCPClient) closeConn(frame CloseFrame, sendClose bool, err error) {
+
+	cli.mu.Lock()
+
+	if cli.status == Stop {
+		cli.mu.Unlock()
+		return
+	}
+
+	cli.status = Stop
+	cli.closeFrame = frame
+
+	// wait 1 sec if has message not sent in chan.
+	for t := 5; len(cli.msgSendChan) > 0 && t > 0; t-- {
+		cli.debugLogger.logger.Print("wait hangup. ", t)
+		<-time.NewTimer(200 * time.Millisecond).C
+	}
 
-		// wait 1 sec if has message not sent in chan.
-		for t := 5; len(cli.msgSendChan) > 0 && t > 0; t-- {
-			cli.debugLogger.logger.Print("wait hangup. ", t)
-			<-time.NewTimer(200 * time.Millisecond).C
+	if sendClose {
+		if err := writeCloseFrame(cli.connect, frame, cli.writeDeadline); err != nil {
+			cli.debugLogger.Printf("Client %s close frame send error. %v", cli.name, err)
 		}
+	}
+
+	cli.hangupSign <- true
+	cli.UpdateLastActive()
+	cli.debugLogger.Printf("Client hangup %s on %s->%s. code: %d, reason: %s",
+		cli.name, cli.connect.LocalAddr().String(), cli.connect.RemoteAddr().String(), frame.Code, frame.Reason)
+
+	reconnect := cli.reconnectInterval > 0 && !cli.hungUp &&
+		frame.Code != CloseKicked && frame.Code != ClosePolicyViolation
+
+	cli.mu.Unlock()
+
+	if cli.connListener != nil {
+		cli.connListener.OnDisconnected(cli, frame, err)
+	}
 
-		cli.hangupSign <- true
-		cli.UpdateLastActive()
-		cli.debugLogger.Printf("Client hangup %s on %s->%s. reason: %s",
-			cli.name, cli.connect.LocalAddr().String(), cli.connect.RemoteAddr().String(), reason)
+	if reconnect {
+		go cli.reconnect()
 	}
 }
 
+// reconnect redial until succeeded, hangup or max attempts reached.
+func (cli *TCPClient) reconnect() {
+	interval := cli.reconnectInterval
+
+	for attempt := 1; cli.reconnectAttempts == 0 || attempt <= cli.reconnectAttempts; attempt++ {
+		cli.mu.Lock()
+		hungUp := cli.hungUp
+		cli.mu.Unlock()
+		if hungUp {
+			return
+		}
+
+		if cli.connListener != nil {
+			cli.connListener.OnReconnecting(cli, attempt)
+		}
+
+		<-time.NewTimer(interval).C
+
+		_, err := cli.dial(context.Background())
+		if err == nil {
+			return
+		}
+
+		cli.debugLogger.Printf("Client %s reconnect attempt %d failure. %v", cli.name, attempt, err)
+		var rejected *AuthRejectedError
+		if errors.As(err, &rejected) {
+			cli.logger.Printf("Client %s reconnect stop. %v", cli.name, err)
+			return
+		}
+
+		if interval *= 2; interval > clientMaxReconnectInterval {
+			interval = clientMaxReconnectInterval
+		}
+	}
+
+	cli.logger.Printf("Client %s reconnect stop, max attempts reached.", cli.name)
+}
+
 func (cli *TCPClient) UpdateLastActive() {
-	cli.lastActive = time.Now()
+	atomic.StoreInt64(&cli.lastActive, time.Now().UnixNano())
 }
 
 func (cli *TCPClient) handleConnect(ctx context.Context) {
-	go cli.handleWrite(ctx)
-	go cli.handleRead(ctx)
+	cli.loops.Add(2)
+	go func() {
+		defer cli.loops.Done()
+		cli.handleWrite(ctx)
+	}()
+	go func() {
+		defer cli.loops.Done()
+		cli.handleRead(ctx)
+	}()
 }
 
 func (cli *TCPClient) handleWrite(ctx context.Context) {
+	// Heartbeat scheduled on the timing wheel shared by all clients, not a timer per loop
+	heartbeat := sharedTimeWheel().newTicker(cli.heartbeat)
+	defer heartbeat.stop()
+
+	batch := make([]*Packet, 0, maxWriteBatch)
+
 	for {
 		select {
 
@@ -233,40 +619,78 @@ func (cli *TCPClient) handleWrite(ctx context.Context) {
 			cli.logger.Println("Client stop handle write.")
 			return
 
+		// Coalesce the messages already queued into one write
 		case msg := <-cli.msgSendChan:
+			pacs := batch[:0]
+			for {
+				pac, ok := cli.messagePacket(ctx, msg)
+				if !ok {
+					return
+				}
+				pacs = append(pacs, pac)
 
-			data, err := cli.codec.Encode(ctx, msg, cli)
-
-			if err != nil {
-				cli.Hangup(fmt.Sprint("encode data error.", err))
-				return
+				if len(pacs) >= maxWriteBatch || len(cli.msgSendChan) == 0 {
+					break
+				}
+				msg = <-cli.msgSendChan // Only this goroutine receive, not block
 			}
 
-			size := uint32(len(data))
-			if size > cli.maxPacketBodyLen {
-				cli.Hangup(fmt.Sprintf("Send packet size(%d) exceed max limit. ", size))
-				return
-			}
+			clientSendPackets(ctx, cli.packetHandler, pacs, cli)
 
-			pac := NewPacket(PacketVersion, size, data, adler32.Checksum(data))
+			for i := range pacs { // Not hold the bodies until the next batch
+				pacs[i] = nil
+			}
 
+		case pac := <-cli.ctrlSendChan:
 			cli.packetHandler.PacketSend(ctx, pac, cli)
 
-		case <-time.After(cli.heartbeat):
-			if cli.lastActive.Add(cli.heartbeat).After(time.Now()) {
+		case <-heartbeat.C:
+			// Active in the last period. One tick slack, the last ping was sent just after the last tick.
+			if time.Unix(0, atomic.LoadInt64(&cli.lastActive)).Add(cli.heartbeat - timeWheelTick).After(time.Now()) {
 				cli.debugLogger.Printf("Cli %s healthy check.", cli.name)
 				continue
 			}
 
+			if cli.maxMissed > 0 && cli.rtt.missedPongs() >= cli.maxMissed {
+				cli.notifyHeartbeat(HeartbeatEvent{Type: HeartbeatTimeout, Missed: cli.rtt.missedPongs()})
+				cli.disconnect(CloseHeartbeatTimeout, ReasonHeartbeatTimeout, nil)
+				return
+			}
+
 			// Heartbeat can represent 256 instructions. 0: ping; 1: pong
-			pac := NewHeartbeatPacket(HeartbeatCmdPing)
+			pac, missed := cli.rtt.heartbeatPing()
 
 			cli.packetHandler.PacketSend(ctx, pac, cli)
-			cli.debugLogger.Printf("Cli %s healthy check, ping sent.", cli.name)
+			cli.debugLogger.Printf("Cli %s healthy check, ping sent. missed: %d", cli.name, missed)
+			cli.notifyHeartbeat(HeartbeatEvent{Type: HeartbeatPingSent, Missed: missed})
 		}
 	}
 }
 
+// messagePacket encode the message to a packet. Return false if disconnected.
+func (cli *TCPClient) messagePacket(ctx context.Context, msg interface{}) (*Packet, bool) {
+	var data []byte
+	var err error
+	if encoded, ok := msg.(encodedMessage); ok {
+		data = encoded
+	} else {
+		data, err = cli.codec.Encode(ctx, msg, cli)
+	}
+
+	if err != nil {
+		cli.disconnect(CloseInternalError, fmt.Sprint("encode data error.", err), err)
+		return nil, false
+	}
+
+	size := uint32(len(data))
+	if size > cli.maxPacketBodyLen {
+		cli.disconnect(CloseMessageTooBig, fmt.Sprintf("Send packet size(%d) exceed max limit. ", size), nil)
+		return nil, false
+	}
+
+	return NewPacket(PacketVersion, size, data, adler32.Checksum(data)), true
+}
+
 func (cli *TCPClient) handleRead(ctx context.Context) {
 	for {
 		select {
@@ -277,7 +701,7 @@ func (cli *TCPClient) handleRead(ctx context.Context) {
 
 		default:
 			if err := cli.connect.SetReadDeadline(time.Now().Add(cli.readDeadline)); err != nil {
-				cli.Hangup(fmt.Sprint("Set ReadDeadline error. ", err))
+				cli.disconnect(CloseAbnormal, fmt.Sprint("Set ReadDeadline error. ", err), err)
 				return
 			}
 
@@ -289,39 +713,39 @@ func (cli *TCPClient) handleRead(ctx context.Context) {
 					continue
 				}
 				if err.Error() == io.EOF.Error() {
-					cli.Hangup(fmt.Sprint("EOF. ", err))
+					cli.disconnect(CloseAbnormal, fmt.Sprint("EOF. ", err), err)
 				} else {
-					cli.Hangup(fmt.Sprint("Read ver error. ", err))
+					cli.disconnect(CloseAbnormal, fmt.Sprint("Read ver error. ", err), err)
 				}
 				return
 			}
 			if verBuf[0] != PacketVersion && verBuf[0] != PacketHeartbeatVersion {
-				cli.Hangup(fmt.Sprintf("Ver(%s) is wrong.", string(verBuf[0])))
+				cli.disconnect(CloseProtocolError, fmt.Sprintf("Ver(%s) is wrong.", string(verBuf[0])), nil)
 				return
 			}
 
-			// Read size
+			// Read size. Read the rest fully, the packets written in a batch may split over segments.
 			var sizeBuf = make([]byte, 4)
-			if i, err := cli.connect.Read(sizeBuf); i < 4 || err != nil {
-				cli.Hangup(fmt.Sprint("Read packet size error. ", err))
+			if i, err := io.ReadFull(cli.connect, sizeBuf); i < 4 || err != nil {
+				cli.disconnect(CloseAbnormal, fmt.Sprint("Read packet size error. ", err), err)
 				return
 			}
 
 			size := binary.BigEndian.Uint32(sizeBuf)
 			if size > cli.maxPacketBodyLen {
-				cli.Hangup(fmt.Sprintf("Recv packet size(%d) exceed max limit. ", size))
+				cli.disconnect(CloseMessageTooBig, fmt.Sprintf("Recv packet size(%d) exceed max limit. ", size), nil)
 				return
 			}
 
 			var dataBuf = make([]byte, size) // data size + checksum len
-			if i, err := cli.connect.Read(dataBuf); uint32(i) < size || err != nil {
-				cli.Hangup(fmt.Sprint("Read packet body err. ", err))
+			if i, err := io.ReadFull(cli.connect, dataBuf); uint32(i) < size || err != nil {
+				cli.disconnect(CloseAbnormal, fmt.Sprint("Read packet body err. ", err), err)
 				return
 			}
 
 			var checksumBuf = make([]byte, 4)
-			if i, err := cli.connect.Read(checksumBuf); uint32(i) < 4 || err != nil {
-				cli.Hangup(fmt.Sprint("Read packet checksum err. ", err))
+			if i, err := io.ReadFull(cli.connect, checksumBuf); uint32(i) < 4 || err != nil {
+				cli.disconnect(CloseAbnormal, fmt.Sprint("Read packet checksum err. ", err), err)
 				return
 			}
 
@@ -330,22 +754,38 @@ func (cli *TCPClient) handleRead(ctx context.Context) {
 			packet := NewPacket(verBuf[0], size, dataBuf, checksum)
 
 			if !packet.Checksum() {
-				cli.Hangup(fmt.Sprint("Checksum err. Check false."))
+				cli.disconnect(CloseProtocolError, fmt.Sprint("Checksum err. Check false."), nil)
 				return
 			}
 
 			// Heartbeat or message
 			if verBuf[0] == PacketHeartbeatVersion { // Heartbeat
+				if len(packet.body) == 0 {
+					cli.debugLogger.Printf("Cli %s heartbeat empty cmd. checksum: %d", cli.name, packet.checksum)
+					continue
+				}
+
 				if packet.body[0] == HeartbeatCmdPing {
-					// Heartbeat can represent 256 instructions. 0: ping; 1: pong
-					pac := NewHeartbeatPacket(HeartbeatCmdPong)
+					// Heartbeat can represent 256 instructions. 0: ping; 1: pong. Echo the ping payload.
+					pac := NewControlPacket(HeartbeatCmdPong, packet.body[1:])
 
 					cli.packetHandler.PacketSend(ctx, pac, cli)
 					cli.debugLogger.Printf("Client heartbeat pong sent. cli: %s, checksum: %d", cli.name, pac.checksum)
 				}
 
 				if packet.body[0] == HeartbeatCmdPong {
-					cli.debugLogger.Printf("Cli %s healthy check, pong received.", cli.name)
+					rtt, _ := cli.rtt.onPong(packet.body[1:])
+					cli.debugLogger.Printf("Cli %s healthy check, pong received. rtt: %v", cli.name, rtt)
+					cli.notifyHeartbeat(HeartbeatEvent{Type: HeartbeatPongReceived, RTT: rtt})
+				}
+
+				if frame, ok := closeFrameOf(packet); ok { // Closed by server, no need to send close back
+					cli.closeConn(frame, false, nil)
+					return
+				}
+
+				if packet.body[0] >= ControlCmdUserMin {
+					cli.onControlCmd(packet.body[0], packet.body[1:])
 				}
 			} else { // Message
 				cli.packetHandler.PacketReceived(ctx, packet, cli)
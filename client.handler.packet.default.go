@@ -5,9 +5,7 @@
 package gosocket
 
 import (
-	"bytes"
 	"context"
-	"encoding/binary"
 	"fmt"
 	"time"
 )
@@ -22,7 +20,7 @@ func (d defaultClientPacketHander) PacketReceived(ctx context.Context, pac *Pack
 	m, err := cli.codec.Decode(ctx, pac.body, cli)
 
 	if err != nil {
-		cli.Hangup(fmt.Sprint("Packet decode error.", err))
+		cli.disconnect(CloseProtocolError, fmt.Sprint("Packet decode error.", err), err)
 		return
 	}
 
@@ -32,34 +30,25 @@ func (d defaultClientPacketHander) PacketReceived(ctx context.Context, pac *Pack
 	cli.messageListener.OnMessage(ctx, m, cli)
 }
 
-func (d defaultClientPacketHander) PacketSend(_ context.Context, pac *Packet, cli *TCPClient) {
+func (d defaultClientPacketHander) PacketSend(ctx context.Context, pac *Packet, cli *TCPClient) {
+	d.PacketSendBatch(ctx, []*Packet{pac}, cli)
+}
+
+func (d defaultClientPacketHander) PacketSendBatch(_ context.Context, pacs []*Packet, cli *TCPClient) {
 
 	// process chain if need extends
 
 	if err := cli.connect.SetWriteDeadline(time.Now().Add(cli.writeDeadline)); err != nil {
-		cli.Hangup(fmt.Sprint("setWriteDeadline error.", err))
+		cli.disconnect(CloseAbnormal, fmt.Sprint("setWriteDeadline error.", err), err)
 		return
 	}
 
-	dataBuf := new(bytes.Buffer)
-	var errs [4]error
-
-	errs[0] = binary.Write(dataBuf, binary.BigEndian, pac.ver)      // Ver 8 bit
-	errs[1] = binary.Write(dataBuf, binary.BigEndian, pac.len)      // Size 32bit
-	errs[2] = binary.Write(dataBuf, binary.BigEndian, pac.body)     // Data body len
-	errs[3] = binary.Write(dataBuf, binary.BigEndian, pac.checksum) // Checksum 32bit
-
-	for _, err := range errs {
-		if err != nil {
-			cli.Hangup(fmt.Sprintf("Packet to binary error. packetLen: %d. %v", pac.len, err))
-			return
-		}
+	for _, pac := range pacs {
+		cli.debugLogger.Printf("Client packet send. cli: %s, len: %d, checksum: %d.", cli.name, pac.len, pac.checksum)
 	}
 
-	cli.debugLogger.Printf("Client packet send. cli: %s, len: %d, checksum: %d.", cli.name, pac.len, pac.checksum)
-
-	if i, err := cli.connect.Write(dataBuf.Bytes()); err != nil {
-		cli.Hangup(fmt.Sprintf("Packet write to socket error. writeLen: %d. %v", i, err))
+	if i, err := writePackets(cli.connect, pacs); err != nil {
+		cli.disconnect(CloseAbnormal, fmt.Sprintf("Packet write to socket error. writeLen: %d. %v", i, err), err)
 		return
 	}
 	cli.UpdateLastActive()
@@ -0,0 +1,162 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Journal record types
+const (
+	journalRecordPush   byte = 0 // [type][expire unix nano 64bit][len 32bit][data]
+	journalRecordRemove byte = 1 // [type]
+)
+
+// FileOfflineQueue keep the messages in an append only journal file, survive the process restart.
+// - The pending messages are also held in memory. The journal truncated once the queue is empty.
+// - A message handed to the connection but not removed before a crash is sent again after restart.
+type FileOfflineQueue struct {
+	mem        *MemoryOfflineQueue
+	file       *os.File
+	maxDataLen uint32 // Max message length of a record, a longer one is corruption
+	mu         sync.Mutex
+}
+
+// NewFileOfflineQueue open or create the journal at path, the pending messages in it are loaded.
+// - The messages are bounded by the default max packet body length, see NewFileOfflineQueueLimit.
+func NewFileOfflineQueue(path string, maxLen int) (*FileOfflineQueue, error) {
+	return NewFileOfflineQueueLimit(path, maxLen, defaultMaxPacketBodyLength)
+}
+
+// NewFileOfflineQueueLimit open or create the journal at path, the pending messages in it are loaded.
+// - maxDataLen the max packet body length of the client, a longer record in the journal is corruption.
+func NewFileOfflineQueueLimit(path string, maxLen int, maxDataLen uint32) (*FileOfflineQueue, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &FileOfflineQueue{mem: NewMemoryOfflineQueue(maxLen), file: file, maxDataLen: maxDataLen}
+	if err := q.replay(); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return q, nil
+}
+
+// replay load the pending messages from the journal.
+// - A torn record at the tail is truncated, so the records pushed later not follow the garbage.
+func (q *FileOfflineQueue) replay() error {
+	r := bufio.NewReader(q.file)
+	var offset int64 // End of the last complete record
+	for {
+		typ, err := r.ReadByte()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch typ {
+		case journalRecordPush:
+			var head [12]byte
+			if _, err := io.ReadFull(r, head[:]); err != nil {
+				return q.truncateTorn(offset, err)
+			}
+			size := binary.BigEndian.Uint32(head[8:])
+			if size > q.maxDataLen {
+				return errors.New("Offline journal corrupted, record length exceed max limit. ")
+			}
+			data := make([]byte, size)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return q.truncateTorn(offset, err)
+			}
+			offset += 13 + int64(size)
+
+			msg := OfflineMessage{Data: data}
+			if expire := int64(binary.BigEndian.Uint64(head[:8])); expire != 0 {
+				msg.Expire = time.Unix(0, expire)
+			}
+			q.mem.messages = append(q.mem.messages, msg) // Not limit on replay, keep all persisted
+		case journalRecordRemove:
+			_ = q.mem.Remove()
+			offset++
+		default:
+			return errors.New("Offline journal corrupted. ")
+		}
+	}
+}
+
+// truncateTorn truncate the journal to the end of the last complete record, if the record after is torn.
+func (q *FileOfflineQueue) truncateTorn(offset int64, err error) error {
+	if err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	return q.file.Truncate(offset)
+}
+
+func (q *FileOfflineQueue) Push(msg OfflineMessage) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.mem.Len() >= q.mem.maxLen {
+		return ErrOfflineQueueFull
+	}
+
+	var expire int64
+	if !msg.Expire.IsZero() {
+		expire = msg.Expire.UnixNano()
+	}
+
+	record := make([]byte, 13+len(msg.Data))
+	record[0] = journalRecordPush
+	binary.BigEndian.PutUint64(record[1:], uint64(expire))
+	binary.BigEndian.PutUint32(record[9:], uint32(len(msg.Data)))
+	copy(record[13:], msg.Data)
+
+	if _, err := q.file.Write(record); err != nil {
+		return err
+	}
+	return q.mem.Push(msg)
+}
+
+func (q *FileOfflineQueue) Peek() (OfflineMessage, bool) {
+	return q.mem.Peek()
+}
+
+func (q *FileOfflineQueue) Remove() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.mem.Len() == 0 {
+		return nil
+	}
+	_ = q.mem.Remove()
+
+	if q.mem.Len() == 0 {
+		return q.file.Truncate(0)
+	}
+	_, err := q.file.Write([]byte{journalRecordRemove})
+	return err
+}
+
+func (q *FileOfflineQueue) Len() int {
+	return q.mem.Len()
+}
+
+// Close close the journal file
+func (q *FileOfflineQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.file.Close()
+}
@@ -0,0 +1,142 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrOfflineQueueFull is returned by SendMessage when the client disconnected and the offline queue is full.
+var ErrOfflineQueueFull = errors.New("Offline queue full. ")
+
+// OfflineMessage a message queued while the client disconnected. Data is encoded by the client codec.
+type OfflineMessage struct {
+	Data   []byte
+	Expire time.Time // Zero: never expire
+}
+
+// Expired return the message expired at the time
+func (m OfflineMessage) Expired(now time.Time) bool {
+	return !m.Expire.IsZero() && now.After(m.Expire)
+}
+
+// OfflineQueue bounded FIFO of the messages sent while the client disconnected.
+// - Peek the head and Remove it after it is handed to the connection, so a crash in between not lose it.
+type OfflineQueue interface {
+	Push(msg OfflineMessage) error // Return ErrOfflineQueueFull if full
+	Peek() (OfflineMessage, bool)  // Return false if empty
+	Remove() error                 // Remove the head
+	Len() int
+}
+
+// ======== ======== Memory offline queue ======== ========
+// MemoryOfflineQueue keep the messages in memory, lost on the process exit.
+type MemoryOfflineQueue struct {
+	messages []OfflineMessage
+	maxLen   int
+	mu       sync.Mutex
+}
+
+// NewMemoryOfflineQueue create a memory offline queue holds up to maxLen messages
+func NewMemoryOfflineQueue(maxLen int) *MemoryOfflineQueue {
+	return &MemoryOfflineQueue{maxLen: maxLen}
+}
+
+func (q *MemoryOfflineQueue) Push(msg OfflineMessage) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.messages) >= q.maxLen {
+		return ErrOfflineQueueFull
+	}
+	q.messages = append(q.messages, msg)
+	return nil
+}
+
+func (q *MemoryOfflineQueue) Peek() (OfflineMessage, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.messages) == 0 {
+		return OfflineMessage{}, false
+	}
+	return q.messages[0], true
+}
+
+func (q *MemoryOfflineQueue) Remove() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.messages) > 0 {
+		q.messages[0] = OfflineMessage{}
+		q.messages = q.messages[1:]
+	}
+	return nil
+}
+
+func (q *MemoryOfflineQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.messages)
+}
+
+// ======== ======== Client offline send ======== ========
+
+// enqueueOffline encode the message and push it to the offline queue. Called with cli.mu held.
+func (cli *TCPClient) enqueueOffline(msg interface{}, ttl time.Duration) error {
+	data, err := cli.codec.Encode(context.Background(), msg, cli)
+	if err != nil {
+		return err
+	}
+	if uint32(len(data)) > cli.maxPacketBodyLen {
+		return errors.New("Offline message exceed max packet body length. ")
+	}
+
+	offline := OfflineMessage{Data: data}
+	if ttl > 0 {
+		offline.Expire = time.Now().Add(ttl)
+	}
+	return cli.offlineQueue.Push(offline)
+}
+
+// flushOffline send the queued messages in order on the connection of ctx.
+// - SendMessage keep queuing until flushed, so the new messages not overtake the queued.
+// - Stop and keep the rest if the connection dropped again.
+func (cli *TCPClient) flushOffline(ctx context.Context) {
+	for {
+		cli.mu.Lock()
+		if cli.status != Running || ctx.Err() != nil {
+			cli.mu.Unlock()
+			return
+		}
+		msg, ok := cli.offlineQueue.Peek()
+		if !ok {
+			cli.flushing = false
+			cli.mu.Unlock()
+			cli.debugLogger.Printf("Client %s offline queue flushed.", cli.name)
+			return
+		}
+		cli.mu.Unlock()
+
+		if !msg.Expired(time.Now()) {
+			select {
+			case <-ctx.Done():
+				return
+			case cli.msgSendChan <- encodedMessage(msg.Data):
+			}
+		} else {
+			cli.debugLogger.Printf("Client %s offline message expired, dropped. len: %d", cli.name, len(msg.Data))
+		}
+
+		if err := cli.offlineQueue.Remove(); err != nil {
+			cli.logger.Printf("Client %s offline queue remove error. %v", cli.name, err)
+			return
+		}
+	}
+}
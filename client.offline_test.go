@@ -0,0 +1,165 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testChanServerListener struct {
+	received chan interface{}
+}
+
+func (t testChanServerListener) OnMessage(_ context.Context, message interface{}, _ *Session) {
+	t.received <- message
+}
+
+func TestOfflineQueueFlush(t *testing.T) {
+	received := make(chan interface{}, 8)
+
+	server, err := NewTCPServer("127.0.0.1:18843").
+		RegisterMessageListener(testChanServerListener{received: received}).
+		SetDebugMode(false).
+		Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	queue := NewMemoryOfflineQueue(3)
+	client := NewTcpClient("127.0.0.1:18843").
+		RegisterMessageListener(&TestExampleClientListener{}).
+		SetOfflineQueue(queue, 0).
+		SetDebugMode(false)
+
+	// Queued before dial
+	if err := client.SendMessage("first"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SendMessageWithTTL("expired", time.Nanosecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SendMessage("third"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SendMessage("over"); err != ErrOfflineQueueFull {
+		t.Fatalf("expect ErrOfflineQueueFull, got %v", err)
+	}
+	time.Sleep(time.Millisecond)
+
+	if _, err := client.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Hangup("Test done.")
+
+	for deadline := time.Now().Add(3 * time.Second); queue.Len() > 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := client.SendMessage("fourth"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"first", "third", "fourth"} {
+		select {
+		case msg := <-received:
+			if msg != want {
+				t.Fatalf("expect %s, got %v", want, msg)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("expect %s, timeout", want)
+		}
+	}
+}
+
+func TestFileOfflineQueue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "offline.journal")
+
+	q, err := NewFileOfflineQueue(path, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range []string{"a", "b", "c"} {
+		if err := q.Push(OfflineMessage{Data: []byte(data), Expire: time.Now().Add(time.Hour)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = q.Remove()
+	_ = q.Close()
+
+	// Reopen, the removed one not loaded again
+	q, err = NewFileOfflineQueue(path, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	if q.Len() != 2 {
+		t.Fatalf("expect 2 messages replayed, got %d", q.Len())
+	}
+	if msg, _ := q.Peek(); string(msg.Data) != "b" || msg.Expire.IsZero() {
+		t.Fatalf("expect head b with expire, got %s %v", msg.Data, msg.Expire)
+	}
+}
+
+func TestFileOfflineQueueTornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "offline.journal")
+
+	q, err := NewFileOfflineQueue(path, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Push(OfflineMessage{Data: []byte("a")}); err != nil {
+		t.Fatal(err)
+	}
+	_ = q.Close()
+
+	// Crash in the middle of a push record
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = file.Write([]byte{journalRecordPush, 0, 0, 0})
+	_ = file.Close()
+
+	// The torn record truncated, the one pushed after replayed
+	q, err = NewFileOfflineQueue(path, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Push(OfflineMessage{Data: []byte("b")}); err != nil {
+		t.Fatal(err)
+	}
+	_ = q.Close()
+
+	q, err = NewFileOfflineQueue(path, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	if q.Len() != 2 {
+		t.Fatalf("expect 2 messages replayed, got %d", q.Len())
+	}
+	_ = q.Remove()
+	if msg, _ := q.Peek(); string(msg.Data) != "b" {
+		t.Fatalf("expect b after the torn record, got %s", msg.Data)
+	}
+
+	// A length over the limit is corruption, not allocated
+	file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = file.Write([]byte{journalRecordPush, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff})
+	_ = file.Close()
+
+	if _, err := NewFileOfflineQueueLimit(path, 8, 1024); err == nil {
+		t.Fatal("expect corrupted error of the oversize record")
+	}
+}
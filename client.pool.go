@@ -0,0 +1,291 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BalancePolicy how the ClientPool pick a connection for each send
+type BalancePolicy int
+
+const (
+	// BalanceRoundRobin pick the healthy connections in turn
+	BalanceRoundRobin BalancePolicy = iota
+	// BalanceLeastPending pick the healthy connection with the fewest messages waiting to be sent
+	BalanceLeastPending
+	// BalanceConsistentHash pick the server address by the hash of the key, the same key go to the same server
+	// while it is healthy. Only the keys of an unhealthy server move to others.
+	BalanceConsistentHash
+)
+
+const (
+	poolVirtualNodes             = 128             // Virtual nodes of each address on the consistent hash ring
+	poolDefaultReconnectInterval = 1 * time.Second // Auto reconnect interval if the client not set
+)
+
+// ClientPool keep N connections to each of the server addresses, and spread the sends between them.
+// - A connection is taken out of rotation on disconnected, include heartbeat timeout (see TCPClient.SetMaxMissedPongs),
+//   and back on reconnected.
+type ClientPool struct {
+	addrs        []string
+	connsPerAddr int
+	policy       BalancePolicy
+	newClient    func(addr string) *TCPClient
+	members      []*poolMember
+	ring         []poolRingNode // Sorted by hash
+	next         uint32         // Round robin cursor, atomic
+	mu           sync.Mutex
+}
+
+type poolMember struct {
+	addr    string
+	cli     *TCPClient
+	healthy int32 // atomic, 1: in rotation
+}
+
+type poolRingNode struct {
+	hash uint32
+	addr string
+}
+
+// NewClientPool create a client pool.
+// - newClient create and config each client (codec, message listener...) of the address. Not dial it.
+//   Auto reconnect is enabled with poolDefaultReconnectInterval if the client not set it.
+func NewClientPool(addrs []string, connsPerAddr int, policy BalancePolicy, newClient func(addr string) *TCPClient) *ClientPool {
+	if connsPerAddr < 1 {
+		connsPerAddr = 1
+	}
+
+	p := &ClientPool{
+		addrs:        addrs,
+		connsPerAddr: connsPerAddr,
+		policy:       policy,
+		newClient:    newClient,
+	}
+
+	for _, addr := range addrs {
+		for i := 0; i < poolVirtualNodes; i++ {
+			p.ring = append(p.ring, poolRingNode{hash: poolHash(addr + "#" + strconv.Itoa(i)), addr: addr})
+		}
+	}
+	sort.Slice(p.ring, func(i, j int) bool { return p.ring[i].hash < p.ring[j].hash })
+
+	return p
+}
+
+// Dial dial all the connections. Return error only if none connected.
+// - The failed connections keep redialing in background, join the rotation once connected.
+func (p *ClientPool) Dial(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.members) > 0 {
+		return errors.New("Client pool already dialed. ")
+	}
+
+	var lastErr error
+	connected := 0
+	for _, addr := range p.addrs {
+		for i := 0; i < p.connsPerAddr; i++ {
+			m := &poolMember{addr: addr, cli: p.newClient(addr)}
+			m.cli.connListener = poolConnListener{member: m, next: m.cli.connListener}
+			if m.cli.reconnectInterval <= 0 {
+				m.cli.reconnectInterval = poolDefaultReconnectInterval
+			}
+			p.members = append(p.members, m)
+
+			if _, err := m.cli.DialContext(ctx); err != nil {
+				lastErr = err
+				go m.cli.reconnect()
+				continue
+			}
+			connected++
+		}
+	}
+
+	if connected == 0 && lastErr != nil {
+		return lastErr
+	}
+	return nil
+}
+
+// Pick pick a healthy connection. The key is only used by BalanceConsistentHash.
+func (p *ClientPool) Pick(key string) (*TCPClient, error) {
+	p.mu.Lock()
+	members := p.members
+	p.mu.Unlock()
+
+	var m *poolMember
+	switch p.policy {
+	case BalanceLeastPending:
+		m = p.pickLeastPending(members)
+	case BalanceConsistentHash:
+		m = p.pickHash(members, key)
+	default:
+		m = p.pickRoundRobin(members)
+	}
+
+	if m == nil {
+		return nil, errors.New("Client pool no healthy connection. ")
+	}
+	return m.cli, nil
+}
+
+// SendMessage send the message over a picked connection. Try the next one if it just dropped.
+func (p *ClientPool) SendMessage(msg interface{}) error {
+	return p.SendMessageWithKey("", msg)
+}
+
+// SendMessageWithKey send the message over the connection picked by the key. see BalanceConsistentHash
+func (p *ClientPool) SendMessageWithKey(key string, msg interface{}) error {
+	var err error
+	for tries := 0; tries < 3; tries++ {
+		var cli *TCPClient
+		if cli, err = p.Pick(key); err != nil {
+			return err
+		}
+		if err = cli.SendMessage(msg); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// Healthy return the count of connections in rotation
+func (p *ClientPool) Healthy() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n := 0
+	for _, m := range p.members {
+		if atomic.LoadInt32(&m.healthy) == 1 {
+			n++
+		}
+	}
+	return n
+}
+
+// Clients return all the clients of the pool, healthy or not
+func (p *ClientPool) Clients() []*TCPClient {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	clients := make([]*TCPClient, 0, len(p.members))
+	for _, m := range p.members {
+		clients = append(clients, m.cli)
+	}
+	return clients
+}
+
+// Hangup hangup all the connections, stop redialing.
+func (p *ClientPool) Hangup(reason string) {
+	for _, cli := range p.Clients() {
+		cli.mu.Lock()
+		status := cli.status
+		cli.hungUp = true
+		cli.mu.Unlock()
+
+		if status == Running {
+			cli.Hangup(reason)
+		}
+	}
+}
+
+func (p *ClientPool) pickRoundRobin(members []*poolMember) *poolMember {
+	n := uint32(len(members))
+	start := atomic.AddUint32(&p.next, 1)
+	for i := uint32(0); i < n; i++ {
+		if m := members[(start+i)%n]; atomic.LoadInt32(&m.healthy) == 1 {
+			return m
+		}
+	}
+	return nil
+}
+
+func (p *ClientPool) pickLeastPending(members []*poolMember) *poolMember {
+	var picked *poolMember
+	n := uint32(len(members))
+	start := atomic.AddUint32(&p.next, 1) // Start point rotate, so ties not always go to the first
+	for i := uint32(0); i < n; i++ {
+		m := members[(start+i)%n]
+		if atomic.LoadInt32(&m.healthy) != 1 {
+			continue
+		}
+		if picked == nil || m.cli.Pending() < picked.cli.Pending() {
+			picked = m
+		}
+	}
+	return picked
+}
+
+func (p *ClientPool) pickHash(members []*poolMember, key string) *poolMember {
+	if len(p.ring) == 0 {
+		return nil
+	}
+
+	h := poolHash(key)
+	start := sort.Search(len(p.ring), func(i int) bool { return p.ring[i].hash >= h })
+
+	// Walk the ring clockwise to the first address has healthy connection.
+	tried := make(map[string]bool, len(p.addrs))
+	for i := 0; i < len(p.ring) && len(tried) < len(p.addrs); i++ {
+		addr := p.ring[(start+i)%len(p.ring)].addr
+		if tried[addr] {
+			continue
+		}
+		tried[addr] = true
+
+		var healthy []*poolMember
+		for _, m := range members {
+			if m.addr == addr && atomic.LoadInt32(&m.healthy) == 1 {
+				healthy = append(healthy, m)
+			}
+		}
+		if len(healthy) > 0 {
+			return healthy[h%uint32(len(healthy))]
+		}
+	}
+	return nil
+}
+
+func poolHash(s string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(s))
+	return h.Sum32()
+}
+
+// poolConnListener track the health of the member, and pass the events to the client own listener.
+type poolConnListener struct {
+	member *poolMember
+	next   ClientConnectionListener
+}
+
+func (l poolConnListener) OnConnected(cli *TCPClient) {
+	atomic.StoreInt32(&l.member.healthy, 1)
+	if l.next != nil {
+		l.next.OnConnected(cli)
+	}
+}
+
+func (l poolConnListener) OnDisconnected(cli *TCPClient, reason CloseFrame, err error) {
+	atomic.StoreInt32(&l.member.healthy, 0)
+	if l.next != nil {
+		l.next.OnDisconnected(cli, reason, err)
+	}
+}
+
+func (l poolConnListener) OnReconnecting(cli *TCPClient, attempt int) {
+	if l.next != nil {
+		l.next.OnReconnecting(cli, attempt)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestClientPool(t *testing.T) {
+	addrs := []string{"127.0.0.1:18841", "127.0.0.1:18842"}
+	servers := make([]*TCPServer, 0, len(addrs))
+	for _, addr := range addrs {
+		server, err := NewTCPServer(addr).
+			RegisterMessageListener(&TestExampleServerMessageListener{}).
+			SetDebugMode(false).
+			Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer server.Stop()
+		servers = append(servers, server)
+	}
+
+	newClient := func(addr string) *TCPClient {
+		return NewTcpClient(addr).
+			RegisterMessageListener(&TestExampleClientListener{}).
+			SetAutoReconnect(time.Hour, 0).
+			SetDebugMode(false)
+	}
+
+	// Round robin cover every connection
+	pool := NewClientPool(addrs, 2, BalanceRoundRobin, newClient)
+	if err := pool.Dial(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Hangup("Test done.")
+
+	picked := make(map[*TCPClient]bool)
+	for i := 0; i < 4; i++ {
+		cli, err := pool.Pick("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		picked[cli] = true
+	}
+	if len(picked) != 4 {
+		t.Fatalf("expect round robin pick 4 connections, got %d", len(picked))
+	}
+
+	// Consistent hash, the same key the same server
+	hashPool := NewClientPool(addrs, 2, BalanceConsistentHash, newClient)
+	if err := hashPool.Dial(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer hashPool.Hangup("Test done.")
+
+	first, _ := hashPool.Pick("user-42")
+	for i := 0; i < 10; i++ {
+		if cli, _ := hashPool.Pick("user-42"); cli != first {
+			t.Fatal("expect the same key picked the same connection")
+		}
+	}
+
+	// The server of the key down, the key move to the other server
+	for i, addr := range addrs {
+		if addr == first.serverAddr {
+			_ = servers[i].Stop()
+		}
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for hashPool.Healthy() != 2 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if hashPool.Healthy() != 2 {
+		t.Fatalf("expect 2 healthy connections, got %d", hashPool.Healthy())
+	}
+	if cli, err := hashPool.Pick("user-42"); err != nil || cli.serverAddr == first.serverAddr {
+		t.Fatalf("expect the key moved off %s, got %v", first.serverAddr, err)
+	}
+	if err := hashPool.SendMessageWithKey("user-42", "Hello"); err != nil {
+		t.Fatal(err)
+	}
+}
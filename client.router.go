@@ -0,0 +1,110 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"context"
+	"reflect"
+	"sync"
+)
+
+// ClientHandlerFunc handle one routed message on client
+type ClientHandlerFunc func(ctx context.Context, message interface{}, cli *TCPClient)
+
+// ClientMiddleware wrap a ClientHandlerFunc
+type ClientMiddleware func(next ClientHandlerFunc) ClientHandlerFunc
+
+// ClientRouter a ClientMessageListener dispatch messages to handlers by string route or by Go type.
+// - Same match rules as Router.
+type ClientRouter struct {
+	routes      map[string]ClientHandlerFunc
+	types       map[reflect.Type]ClientHandlerFunc
+	middlewares []ClientMiddleware
+	fallback    ClientHandlerFunc
+	routeFunc   func(message interface{}) (string, bool)
+	mu          sync.RWMutex
+}
+
+func NewClientRouter() *ClientRouter {
+	return &ClientRouter{
+		routes:    make(map[string]ClientHandlerFunc),
+		types:     make(map[reflect.Type]ClientHandlerFunc),
+		routeFunc: defaultRouteFunc,
+	}
+}
+
+// Use add middlewares applied to every handler, including the fallback.
+func (r *ClientRouter) Use(middlewares ...ClientMiddleware) *ClientRouter {
+	r.mu.Lock()
+	r.middlewares = append(r.middlewares, middlewares...)
+	r.mu.Unlock()
+	return r
+}
+
+// Handle register handler for the string route, with the route middlewares.
+func (r *ClientRouter) Handle(route string, handler ClientHandlerFunc, middlewares ...ClientMiddleware) *ClientRouter {
+	r.mu.Lock()
+	r.routes[route] = clientChain(handler, middlewares)
+	r.mu.Unlock()
+	return r
+}
+
+// HandleType register handler for the Go type of sample, with the route middlewares.
+func (r *ClientRouter) HandleType(sample interface{}, handler ClientHandlerFunc, middlewares ...ClientMiddleware) *ClientRouter {
+	r.mu.Lock()
+	r.types[reflect.TypeOf(sample)] = clientChain(handler, middlewares)
+	r.mu.Unlock()
+	return r
+}
+
+// Fallback handle the messages no route matched.
+func (r *ClientRouter) Fallback(handler ClientHandlerFunc) *ClientRouter {
+	r.mu.Lock()
+	r.fallback = handler
+	r.mu.Unlock()
+	return r
+}
+
+// SetRouteFunc custom how to take the route from a decoded message.
+func (r *ClientRouter) SetRouteFunc(routeFunc func(message interface{}) (string, bool)) *ClientRouter {
+	r.mu.Lock()
+	r.routeFunc = routeFunc
+	r.mu.Unlock()
+	return r
+}
+
+// OnMessage ClientMessageListener impl
+func (r *ClientRouter) OnMessage(ctx context.Context, message interface{}, cli *TCPClient) {
+	r.mu.RLock()
+	handler := r.match(message)
+	middlewares := r.middlewares
+	r.mu.RUnlock()
+
+	if handler == nil {
+		cli.debugLogger.Printf("ClientRouter no route matched. cli: %s, message type: %T", cli.name, message)
+		return
+	}
+
+	clientChain(handler, middlewares)(ctx, message, cli)
+}
+
+func (r *ClientRouter) match(message interface{}) ClientHandlerFunc {
+	if route, ok := r.routeFunc(message); ok {
+		if handler, ok := r.routes[route]; ok {
+			return handler
+		}
+	}
+	if handler, ok := r.types[reflect.TypeOf(message)]; ok {
+		return handler
+	}
+	return r.fallback
+}
+
+func clientChain(handler ClientHandlerFunc, middlewares []ClientMiddleware) ClientHandlerFunc {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
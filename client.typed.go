@@ -0,0 +1,95 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+//go:build go1.18
+// +build go1.18
+
+package gosocket
+
+import (
+	"context"
+)
+
+// TypedClientMessageListener typed client message processor interface
+type TypedClientMessageListener[In, Out any] interface {
+	OnMessage(ctx context.Context, message In, cli *TypedClient[In, Out])
+}
+
+// TypedClientMessageListenerFunc adapt a func to TypedClientMessageListener
+type TypedClientMessageListenerFunc[In, Out any] func(ctx context.Context, message In, cli *TypedClient[In, Out])
+
+func (f TypedClientMessageListenerFunc[In, Out]) OnMessage(ctx context.Context, message In, cli *TypedClient[In, Out]) {
+	f(ctx, message, cli)
+}
+
+// typedClientMessageListener adapt TypedClientMessageListener to ClientMessageListener
+type typedClientMessageListener[In, Out any] struct {
+	listener TypedClientMessageListener[In, Out]
+	cli      *TypedClient[In, Out]
+}
+
+func (l typedClientMessageListener[In, Out]) OnMessage(ctx context.Context, message interface{}, cli *TCPClient) {
+	m, ok := message.(In)
+	if !ok {
+		cli.debugLogger.Printf("Typed listener message type mismatch. cli: %s, type: %T", cli.name, message)
+		return
+	}
+	l.listener.OnMessage(ctx, m, l.cli)
+}
+
+// TypedClient type-safe wrapper of TCPClient. In: server -> client message, Out: client -> server message.
+// Usage:
+//
+//	client, _ := NewTypedClient[*Response, *Request]("[::1]:8888", JSONClientCodec[*Response, *Request]{}).
+//		RegisterMessageListener(TypedClientMessageListenerFunc[*Response, *Request](
+//			func(ctx context.Context, resp *Response, cli *TypedClient[*Response, *Request]) {
+//				log.Print(resp.ID)
+//			})).
+//		Dial()
+//
+//	_ = client.SendMessage(&Request{ID: 1})
+type TypedClient[In, Out any] struct {
+	client *TCPClient
+}
+
+// NewTypedClient create a typed tcp client, codec is required.
+func NewTypedClient[In, Out any](serverAddr string, codec TypedClientCodec[In, Out]) *TypedClient[In, Out] {
+	return &TypedClient[In, Out]{
+		client: NewTcpClient(serverAddr).SetCodec(typedClientCodec[In, Out]{codec: codec}),
+	}
+}
+
+// Client return the underlying TCPClient
+func (tc *TypedClient[In, Out]) Client() *TCPClient {
+	return tc.client
+}
+
+func (tc *TypedClient[In, Out]) RegisterMessageListener(listener TypedClientMessageListener[In, Out]) *TypedClient[In, Out] {
+	tc.client.RegisterMessageListener(typedClientMessageListener[In, Out]{listener: listener, cli: tc})
+	return tc
+}
+
+func (tc *TypedClient[In, Out]) Dial() (*TypedClient[In, Out], error) {
+	if _, err := tc.client.Dial(); err != nil {
+		return nil, err
+	}
+	return tc, nil
+}
+
+// DialContext see TCPClient.DialContext
+func (tc *TypedClient[In, Out]) DialContext(ctx context.Context) (*TypedClient[In, Out], error) {
+	if _, err := tc.client.DialContext(ctx); err != nil {
+		return nil, err
+	}
+	return tc, nil
+}
+
+// SendMessage send a typed message to the server
+func (tc *TypedClient[In, Out]) SendMessage(message Out) error {
+	return tc.client.SendMessage(message)
+}
+
+func (tc *TypedClient[In, Out]) Hangup(reason string) {
+	tc.client.Hangup(reason)
+}
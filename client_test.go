@@ -8,7 +8,10 @@
 package gosocket
 
 import (
+	"context"
+	"errors"
 	"log"
+	"strings"
 	"testing"
 	"time"
 )
@@ -48,3 +51,142 @@ func TestTCPClient_SendMessage(t *testing.T) {
 	client.Hangup("...Test")
 	_ = server.Stop()
 }
+
+type testConnectionListener struct {
+	events chan string
+}
+
+func (t testConnectionListener) OnConnected(_ *TCPClient) {
+	t.events <- "connected"
+}
+
+func (t testConnectionListener) OnDisconnected(_ *TCPClient, reason CloseFrame, _ error) {
+	t.events <- "disconnected:" + reason.Code.String()
+}
+
+func (t testConnectionListener) OnReconnecting(_ *TCPClient, _ int) {
+	t.events <- "reconnecting"
+}
+
+func TestTCPClient_AutoReconnect(t *testing.T) {
+	created := make(chan *Session, 2)
+
+	server, err := NewTCPServer("127.0.0.1:18839").
+		RegisterMessageListener(&TestExampleServerMessageListener{}).
+		RegisterSessionListener(testCloseFrameSessionListener{created: created, closed: make(chan CloseFrame, 3)}).
+		SetDebugMode(false).
+		Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	events := make(chan string, 16)
+	client, err := NewTcpClient("127.0.0.1:18839").
+		RegisterMessageListener(&TestExampleClientListener{}).
+		RegisterConnectionListener(testConnectionListener{events: events}).
+		SetAutoReconnect(100*time.Millisecond, 3).
+		SetDebugMode(false).
+		Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := func(want ...string) {
+		for _, w := range want {
+			select {
+			case e := <-events:
+				if e != w {
+					t.Fatalf("expect event %s, got %s", w, e)
+				}
+			case <-time.After(3 * time.Second):
+				t.Fatalf("expect event %s, timeout", w)
+			}
+		}
+	}
+
+	expect("connected")
+
+	// Dropped by server, reconnect
+	(<-created).CloseSessionWithCode(CloseGoingAway, "Restart.")
+	expect("disconnected:Going away", "reconnecting", "connected")
+
+	// Hangup by application, no reconnect
+	<-created
+	client.Hangup("Test done.")
+	expect("disconnected:Normal")
+
+	select {
+	case e := <-events:
+		t.Fatalf("expect no event after hangup, got %s", e)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestTCPClient_DialContext(t *testing.T) {
+	dialKind := func(addr string, ctx context.Context) DialErrorKind {
+		_, err := NewTcpClient(addr).
+			RegisterMessageListener(&TestExampleClientListener{}).
+			SetDialTimeout(time.Second).
+			SetDebugMode(false).
+			DialContext(ctx)
+		var dialErr *DialError
+		if !errors.As(err, &dialErr) {
+			t.Fatalf("expect DialError, got %v", err)
+		}
+		return dialErr.Kind
+	}
+
+	if kind := dialKind("127.0.0.1:18840", context.Background()); kind != DialErrorRefused {
+		t.Fatalf("expect Refused, got %s", kind)
+	}
+	if kind := dialKind("gosocket.invalid:18840", context.Background()); kind != DialErrorDNS {
+		t.Fatalf("expect DNS, got %s", kind)
+	}
+
+	_, err := NewTcpClient("127.0.0.1:18840").
+		RegisterMessageListener(&TestExampleClientListener{}).
+		SetLocalAddr("127.0.0.1:not-a-port").
+		SetDebugMode(false).
+		Dial()
+	if dialErr := (*DialError)(nil); !errors.As(err, &dialErr) || dialErr.Kind != DialErrorLocalAddr {
+		t.Fatalf("expect LocalAddr, got %v", err)
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if kind := dialKind("127.0.0.1:18840", canceled); kind != DialErrorCanceled {
+		t.Fatalf("expect Canceled, got %s", kind)
+	}
+
+	expired, cancel2 := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel2()
+	if kind := dialKind("127.0.0.1:18840", expired); kind != DialErrorTimeout {
+		t.Fatalf("expect Timeout, got %s", kind)
+	}
+
+	// Local address binding
+	server, err := NewTCPServer("127.0.0.1:18840").
+		RegisterMessageListener(&TestExampleServerMessageListener{}).
+		SetDebugMode(false).
+		Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	client, err := NewTcpClient("127.0.0.1:18840").
+		RegisterMessageListener(&TestExampleClientListener{}).
+		SetLocalAddr("127.0.0.1:0").
+		SetKeepAlive(30 * time.Second).
+		SetDebugMode(false).
+		DialContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Hangup("Test done.")
+
+	if local := client.connect.LocalAddr().String(); !strings.HasPrefix(local, "127.0.0.1:") {
+		t.Fatalf("expect bound to 127.0.0.1, got %s", local)
+	}
+}
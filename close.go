@@ -0,0 +1,102 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+// CloseCode tell the peer why the connection closed. Sent in the close control packet.
+type CloseCode uint16
+
+const (
+	CloseNormal           CloseCode = 1000 // Closed by the application. CloseSession, Hangup
+	CloseGoingAway        CloseCode = 1001 // Server shutting down, or client going away
+	CloseProtocolError    CloseCode = 1002 // Wrong packet version, checksum or undecodable message
+	CloseAbnormal         CloseCode = 1006 // Connection dropped without a close packet. Never sent.
+	ClosePolicyViolation  CloseCode = 1008 // Rejected by the IP filter
+	CloseMessageTooBig    CloseCode = 1009 // Packet exceed the max packet body length
+	CloseInternalError    CloseCode = 1011 // Codec error or listener panic
+	CloseTryAgainLater    CloseCode = 1013 // Max sessions limit reached
+	CloseKicked           CloseCode = 4000 // Kicked by the server application. Session.Kick
+	CloseIdleTimeout      CloseCode = 4001 // No application message in the idle timeout
+	CloseRateLimited      CloseCode = 4002 // Inbound rate limit exceeded with RateLimitClose
+	CloseHeartbeatTimeout CloseCode = 4003 // Max missed pongs exceeded
+)
+
+var closeCodeNames = map[CloseCode]string{
+	CloseNormal:           "Normal",
+	CloseGoingAway:        "Going away",
+	CloseProtocolError:    "Protocol error",
+	CloseAbnormal:         "Abnormal",
+	ClosePolicyViolation:  "Policy violation",
+	CloseMessageTooBig:    "Message too big",
+	CloseInternalError:    "Internal error",
+	CloseTryAgainLater:    "Try again later",
+	CloseKicked:           "Kicked",
+	CloseIdleTimeout:      "Idle timeout",
+	CloseRateLimited:      "Rate limited",
+	CloseHeartbeatTimeout: "Heartbeat timeout",
+}
+
+func (c CloseCode) String() string {
+	if name, ok := closeCodeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("CloseCode(%d)", uint16(c))
+}
+
+// maxCloseReasonLen the reason text longer than it is truncated on the wire
+const maxCloseReasonLen = 1024
+
+// CloseFrame the code and reason of a closed connection.
+// - Payload of ControlCmdClose: [code 16bit][reason]
+// - Returned by Dial as the error if the server closed the connection during the handshake.
+type CloseFrame struct {
+	Code   CloseCode
+	Reason string
+}
+
+func (f CloseFrame) Error() string {
+	return fmt.Sprintf("Connection closed. code: %d(%s), reason: %s", uint16(f.Code), f.Code, f.Reason)
+}
+
+func (f CloseFrame) encode() []byte {
+	reason := f.Reason
+	if len(reason) > maxCloseReasonLen {
+		reason = reason[:maxCloseReasonLen]
+	}
+
+	payload := make([]byte, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(f.Code))
+	copy(payload[2:], reason)
+	return payload
+}
+
+func decodeCloseFrame(payload []byte) CloseFrame {
+	if len(payload) < 2 {
+		return CloseFrame{Code: CloseProtocolError, Reason: "Close packet malformed."}
+	}
+	return CloseFrame{Code: CloseCode(binary.BigEndian.Uint16(payload)), Reason: string(payload[2:])}
+}
+
+// closeFrameOf return the close frame if the packet is a close control packet
+func closeFrameOf(pac *Packet) (CloseFrame, bool) {
+	if pac.ver != PacketHeartbeatVersion || len(pac.body) == 0 || pac.body[0] != ControlCmdClose {
+		return CloseFrame{}, false
+	}
+	return decodeCloseFrame(pac.body[1:]), true
+}
+
+// writeCloseFrame write the close control packet directly to the conn, before it closed.
+func writeCloseFrame(conn *net.TCPConn, frame CloseFrame, writeDeadline time.Duration) error {
+	if err := conn.SetWriteDeadline(time.Now().Add(writeDeadline)); err != nil {
+		return err
+	}
+	return writePacket(conn, NewControlPacket(ControlCmdClose, frame.encode()))
+}
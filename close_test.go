@@ -0,0 +1,89 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"testing"
+	"time"
+)
+
+type testClientCloseListener struct {
+	closed chan CloseFrame
+}
+
+func (t testClientCloseListener) OnConnected(_ *TCPClient) {}
+
+func (t testClientCloseListener) OnDisconnected(_ *TCPClient, reason CloseFrame, _ error) {
+	t.closed <- reason
+}
+
+func (t testClientCloseListener) OnReconnecting(_ *TCPClient, _ int) {}
+
+type testCloseFrameSessionListener struct {
+	created chan *Session
+	closed  chan CloseFrame
+}
+
+func (t testCloseFrameSessionListener) OnSessionCreate(s *Session) {
+	t.created <- s
+}
+
+func (t testCloseFrameSessionListener) OnSessionClose(s *Session) {
+	t.closed <- s.CloseFrame()
+}
+
+func TestCloseFrame(t *testing.T) {
+	sessions := testCloseFrameSessionListener{created: make(chan *Session, 3), closed: make(chan CloseFrame, 3)}
+
+	server, err := NewTCPServer("127.0.0.1:18838").
+		RegisterMessageListener(&TestExampleServerMessageListener{}).
+		RegisterSessionListener(sessions).
+		SetDebugMode(false).
+		Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dial := func() (*TCPClient, chan CloseFrame) {
+		closed := make(chan CloseFrame, 1)
+		client, err := NewTcpClient("127.0.0.1:18838").
+			RegisterMessageListener(&TestExampleClientListener{}).
+			RegisterConnectionListener(testClientCloseListener{closed: closed}).
+			SetDebugMode(false).
+			Dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return client, closed
+	}
+	expect := func(frames chan CloseFrame, code CloseCode, reason string) {
+		select {
+		case frame := <-frames:
+			if frame.Code != code || frame.Reason != reason {
+				t.Fatalf("expect close %d(%s), got %d(%s)", code, reason, frame.Code, frame.Reason)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("expect close %d(%s), timeout", code, reason)
+		}
+	}
+
+	// Kicked by server
+	_, closed := dial()
+	(<-sessions.created).Kick("Bye.")
+	expect(closed, CloseKicked, "Bye.")
+	expect(sessions.closed, CloseKicked, "Bye.")
+
+	// Hangup by client, the server see the client code
+	client, _ := dial()
+	<-sessions.created
+	client.HangupWithCode(CloseGoingAway, "App exit.")
+	expect(sessions.closed, CloseGoingAway, "App exit.")
+
+	// Server shutting down
+	_, closed = dial()
+	<-sessions.created
+	_ = server.Stop()
+	expect(closed, CloseGoingAway, "Server shutting down.")
+}
@@ -0,0 +1,499 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Cluster frame kinds
+const (
+	clusterHello     = "hello"     // Node -> peer on link dial. Node, SIDs: all local sessions
+	clusterWelcome   = "welcome"   // Peer -> node reply of hello. Node
+	clusterUp        = "up"        // SIDs created
+	clusterDown      = "down"      // SIDs closed
+	clusterBroadcast = "broadcast" // Data to all sessions
+	clusterPublish   = "publish"   // Data to the subscribers of Topic
+	clusterDirect    = "direct"    // Data to session SIDs[0]
+)
+
+const (
+	clusterNodeAttrKey          = "gosocket.cluster.node"
+	clusterDefaultRetryInterval = 3 * time.Second
+)
+
+// clusterFrame the message between cluster nodes
+type clusterFrame struct {
+	Kind  string   `json:"k"`
+	Node  string   `json:"n,omitempty"`
+	SIDs  []string `json:"s,omitempty"`
+	Topic string   `json:"t,omitempty"`
+	Data  []byte   `json:"d,omitempty"`
+}
+
+// Cluster link several TCPServer nodes, forward broadcast, topic and direct-to-session messages between them.
+// - Each node listen a link address, and dial every peer link address. (Full mesh, one hop forward)
+// - Session locations (sID -> node) are shared on link established and on session create/close.
+// - Messages are encoded once by the origin node server Codec, with a nil session. The Codec must allow that.
+// - Groups: join sessions to a topic by TCPServer.Subscribe, and send to the group by Cluster.Publish.
+// Usage:
+//
+//	server := NewTCPServer("0.0.0.0:8888").RegisterMessageListener(&MyListener{})
+//	cluster, _ := NewCluster("node-a", server, "0.0.0.0:9888").
+//		AddPeer("10.0.0.2:9888", "10.0.0.3:9888").
+//		Start() // Before server.Run()
+//	server.Run()
+//
+//	cluster.SendToSession(sID, "Hi!") // The session may on any node.
+type Cluster struct {
+	nodeID        string
+	server        *TCPServer                 // Local client facing server
+	linkServer    *TCPServer                 // Inbound links from peers
+	peerAddrs     []string                   // Peer link addresses
+	links         map[string]*clusterLink    // Outbound links. peer addr -> link
+	peers         map[string]*clusterLink    // Outbound links. peer nodeID -> link
+	locations     map[string]string          // Remote sID -> nodeID
+	nodeSessions  map[string]map[string]bool // Remote nodeID -> sIDs
+	nodeLinks     map[string]string          // Remote nodeID -> current inbound link sID
+	retryInterval time.Duration
+	credential    ClientCredentialProvider
+	stopSign      chan struct{}
+	stopOnce      sync.Once
+	mu            sync.RWMutex
+}
+
+// NewCluster create a cluster node of the server. The server must not be running yet.
+func NewCluster(nodeID string, server *TCPServer, linkAddr string) *Cluster {
+	c := &Cluster{
+		nodeID:        nodeID,
+		server:        server,
+		links:         make(map[string]*clusterLink),
+		peers:         make(map[string]*clusterLink),
+		locations:     make(map[string]string),
+		nodeSessions:  make(map[string]map[string]bool),
+		nodeLinks:     make(map[string]string),
+		retryInterval: clusterDefaultRetryInterval,
+		stopSign:      make(chan struct{}),
+	}
+
+	c.linkServer = NewTCPServer(linkAddr).
+		SetCodec(clusterCodec{}).
+		RegisterMessageListener(clusterLinkServerListener{cluster: c}).
+		RegisterSessionListener(clusterLinkServerListener{cluster: c})
+	c.linkServer.SetDebugMode(server.env == DEBUG)
+	c.linkServer.SetLogger(server.debugLogger.logger, server.logger)
+
+	server.addSessionObserver(clusterSessionObserver{cluster: c})
+
+	return c
+}
+
+// AddPeer add the link addresses of the other nodes
+func (c *Cluster) AddPeer(linkAddrs ...string) *Cluster {
+	c.peerAddrs = append(c.peerAddrs, linkAddrs...)
+	return c
+}
+
+// SetRetryInterval the interval to redial the broken links. Default 3 seconds.
+func (c *Cluster) SetRetryInterval(interval time.Duration) *Cluster {
+	c.retryInterval = interval
+	return c
+}
+
+// SetLinkAuth authenticate the links between nodes. The authenticator on the link server, the credential on the dialer.
+func (c *Cluster) SetLinkAuth(authenticator Authenticator, credential ClientCredentialProvider) *Cluster {
+	c.linkServer.SetAuthenticator(authenticator)
+	c.credential = credential
+	return c
+}
+
+// NodeID return the node ID
+func (c *Cluster) NodeID() string {
+	return c.nodeID
+}
+
+// Start listen the link address and dial the peers.
+func (c *Cluster) Start() (*Cluster, error) {
+	if _, err := c.linkServer.Run(); err != nil {
+		return nil, err
+	}
+
+	for _, addr := range c.peerAddrs {
+		go c.keepLink(addr)
+	}
+
+	return c, nil
+}
+
+// Stop hangup all links and stop the link server. Only the first call take effect.
+func (c *Cluster) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stopSign)
+
+		c.mu.Lock()
+		links := c.links
+		c.links = make(map[string]*clusterLink)
+		c.peers = make(map[string]*clusterLink)
+		c.mu.Unlock()
+
+		for _, link := range links {
+			link.cli.HangupWithCode(CloseGoingAway, "Cluster stop.")
+		}
+		_ = c.linkServer.Stop()
+	})
+}
+
+// Locate return the nodeID the session on
+func (c *Cluster) Locate(sID string) (string, bool) {
+	if _, ok := c.server.Session(sID); ok {
+		return c.nodeID, true
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	nodeID, ok := c.locations[sID]
+	return nodeID, ok
+}
+
+// Broadcast send the message to all sessions on all nodes
+func (c *Cluster) Broadcast(message interface{}) error {
+	data, err := c.encode(message)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range c.server.Sessions() {
+		s.sendEncoded(data)
+	}
+	c.sendPeers(&clusterFrame{Kind: clusterBroadcast, Data: data})
+
+	return nil
+}
+
+// Publish send the message to the subscribers of the topic on all nodes
+func (c *Cluster) Publish(topic string, message interface{}) error {
+	data, err := c.encode(message)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range c.server.topics.subscribers(topic) {
+		s.sendEncoded(data)
+	}
+	c.sendPeers(&clusterFrame{Kind: clusterPublish, Topic: topic, Data: data})
+
+	return nil
+}
+
+// SendToSession send the message to the session, on whichever node it is.
+// - Not block, error returned if the send queue of the session or the link is full.
+func (c *Cluster) SendToSession(sID string, message interface{}) error {
+	if s, ok := c.server.Session(sID); ok {
+		if !s.trySend(message) {
+			return errors.New("Session closed or send queue full. sID: " + sID)
+		}
+		return nil
+	}
+
+	c.mu.RLock()
+	link := c.peers[c.locations[sID]]
+	c.mu.RUnlock()
+
+	if link == nil {
+		return errors.New("Session not found in cluster. sID: " + sID)
+	}
+
+	data, err := c.encode(message)
+	if err != nil {
+		return err
+	}
+	return link.send(&clusterFrame{Kind: clusterDirect, SIDs: []string{sID}, Data: data})
+}
+
+func (c *Cluster) encode(message interface{}) ([]byte, error) {
+	var data []byte
+	var err error
+	if !c.server.safeCall(nil, "Codec.Encode", func() { data, err = c.server.codec.Encode(context.Background(), message, nil) }) {
+		return nil, errors.New("Codec encode panic. ")
+	}
+	return data, err
+}
+
+// sendPeers send the frame to all the linked peers
+func (c *Cluster) sendPeers(frame *clusterFrame) {
+	c.mu.RLock()
+	peers := make([]*clusterLink, 0, len(c.peers))
+	for _, link := range c.peers {
+		peers = append(peers, link)
+	}
+	c.mu.RUnlock()
+
+	for _, link := range peers {
+		if err := link.send(frame); err != nil {
+			c.server.debugLogger.Printf("Cluster send to peer failure. %v", err)
+		}
+	}
+}
+
+// keepLink dial the peer, and redial when the link broken, until the cluster stop.
+func (c *Cluster) keepLink(addr string) {
+	for {
+		c.mu.RLock()
+		link := c.links[addr]
+		c.mu.RUnlock()
+
+		if link == nil || link.cli.Status() != Running {
+			c.dialLink(addr)
+		}
+
+		select {
+		case <-c.stopSign:
+			return
+		case <-time.After(c.retryInterval):
+		}
+	}
+}
+
+func (c *Cluster) dialLink(addr string) {
+	cli := NewTcpClient(addr).
+		SetCodec(clusterClientCodec{}).
+		RegisterMessageListener(clusterLinkClientListener{cluster: c}).
+		SetDebugMode(c.server.env == DEBUG).
+		SetLogger(c.server.debugLogger.logger, c.server.logger)
+	if c.credential != nil {
+		cli.SetCredentialProvider(c.credential)
+	}
+
+	if _, err := cli.Dial(); err != nil {
+		c.server.debugLogger.Printf("Cluster dial peer failure. peer: %s, %v", addr, err)
+		return
+	}
+
+	// Hold the link lock from added to hello queued, so no create/close event is queued before the hello.
+	// Sessions created after added wait the hello, the ones before are in the snapshot.
+	link := &clusterLink{cli: cli}
+	link.mu.Lock()
+	defer link.mu.Unlock()
+
+	c.mu.Lock()
+	c.links[addr] = link
+	c.mu.Unlock()
+
+	sessions := c.server.Sessions()
+	sIDs := make([]string, 0, len(sessions))
+	for sID := range sessions {
+		sIDs = append(sIDs, sID)
+	}
+	if err := cli.trySendMessage(&clusterFrame{Kind: clusterHello, Node: c.nodeID, SIDs: sIDs}); err != nil {
+		c.server.logger.Printf("Cluster hello peer failure. peer: %s, %v", addr, err)
+	}
+}
+
+// onLinkFrame a frame received from a peer inbound link
+func (c *Cluster) onLinkFrame(frame *clusterFrame, linkSession *Session) {
+	switch frame.Kind {
+	case clusterHello:
+		c.mu.Lock()
+		for sID := range c.nodeSessions[frame.Node] {
+			delete(c.locations, sID)
+		}
+		c.nodeSessions[frame.Node] = make(map[string]bool)
+		c.nodeLinks[frame.Node] = linkSession.sID
+		c.mu.Unlock()
+
+		linkSession.SetAttr(clusterNodeAttrKey, frame.Node)
+		c.addLocations(frame.Node, frame.SIDs)
+		linkSession.SendMessage(&clusterFrame{Kind: clusterWelcome, Node: c.nodeID})
+
+	case clusterUp:
+		c.addLocations(c.linkNode(linkSession), frame.SIDs)
+
+	case clusterDown:
+		c.mu.Lock()
+		node := c.linkNode(linkSession)
+		for _, sID := range frame.SIDs {
+			delete(c.locations, sID)
+			delete(c.nodeSessions[node], sID)
+		}
+		c.mu.Unlock()
+
+	case clusterBroadcast:
+		for _, s := range c.server.Sessions() {
+			s.sendEncoded(frame.Data)
+		}
+
+	case clusterPublish:
+		for _, s := range c.server.topics.subscribers(frame.Topic) {
+			s.sendEncoded(frame.Data)
+		}
+
+	case clusterDirect:
+		for _, sID := range frame.SIDs {
+			if s, ok := c.server.Session(sID); ok {
+				s.sendEncoded(frame.Data)
+			}
+		}
+
+	default:
+		c.server.debugLogger.Printf("Cluster unknown frame kind: %s", frame.Kind)
+	}
+}
+
+func (c *Cluster) linkNode(linkSession *Session) string {
+	node, _ := linkSession.Attr(clusterNodeAttrKey).(string)
+	return node
+}
+
+func (c *Cluster) addLocations(node string, sIDs []string) {
+	if node == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.nodeSessions[node] == nil {
+		c.nodeSessions[node] = make(map[string]bool)
+	}
+	for _, sID := range sIDs {
+		c.locations[sID] = node
+		c.nodeSessions[node][sID] = true
+	}
+}
+
+// onLinkClose a peer inbound link closed, forget the sessions of that node.
+func (c *Cluster) onLinkClose(linkSession *Session) {
+	node := c.linkNode(linkSession)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// The node may already relinked.
+	if node == "" || c.nodeLinks[node] != linkSession.sID {
+		return
+	}
+
+	for sID := range c.nodeSessions[node] {
+		delete(c.locations, sID)
+	}
+	delete(c.nodeSessions, node)
+	delete(c.nodeLinks, node)
+}
+
+// ======== ======== Cluster listeners ======== ========
+
+// clusterSessionObserver share the local session create/close to peers
+type clusterSessionObserver struct {
+	cluster *Cluster
+}
+
+func (o clusterSessionObserver) OnSessionCreate(s *Session) {
+	o.cluster.sendLinks(&clusterFrame{Kind: clusterUp, SIDs: []string{s.sID}})
+}
+
+func (o clusterSessionObserver) OnSessionClose(s *Session) {
+	o.cluster.sendLinks(&clusterFrame{Kind: clusterDown, SIDs: []string{s.sID}})
+}
+
+// sendLinks send the frame to all outbound links, including the ones not welcomed yet.
+func (c *Cluster) sendLinks(frame *clusterFrame) {
+	c.mu.RLock()
+	links := make([]*clusterLink, 0, len(c.links))
+	for _, link := range c.links {
+		links = append(links, link)
+	}
+	c.mu.RUnlock()
+
+	for _, link := range links {
+		if err := link.send(frame); err != nil {
+			c.server.debugLogger.Printf("Cluster send to link failure. %v", err)
+		}
+	}
+}
+
+// clusterLink an outbound link to a peer.
+// - mu order the frames queued to the link, the hello first. Only held while queuing, never block.
+type clusterLink struct {
+	cli *TCPClient
+	mu  sync.Mutex
+}
+
+// send queue the frame to the link without blocking, error if disconnected or the queue full.
+func (l *clusterLink) send(frame *clusterFrame) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.cli.trySendMessage(frame)
+}
+
+// clusterLinkServerListener frames and link close on the link server
+type clusterLinkServerListener struct {
+	cluster *Cluster
+}
+
+func (l clusterLinkServerListener) OnMessage(_ context.Context, message interface{}, session *Session) {
+	if frame, ok := message.(*clusterFrame); ok {
+		l.cluster.onLinkFrame(frame, session)
+	}
+}
+
+func (l clusterLinkServerListener) OnSessionCreate(_ *Session) {}
+
+func (l clusterLinkServerListener) OnSessionClose(session *Session) {
+	l.cluster.onLinkClose(session)
+}
+
+// clusterLinkClientListener welcome frame on the outbound links
+type clusterLinkClientListener struct {
+	cluster *Cluster
+}
+
+func (l clusterLinkClientListener) OnMessage(_ context.Context, message interface{}, cli *TCPClient) {
+	frame, ok := message.(*clusterFrame)
+	if !ok || frame.Kind != clusterWelcome {
+		return
+	}
+
+	l.cluster.mu.Lock()
+	for _, link := range l.cluster.links {
+		if link.cli == cli {
+			l.cluster.peers[frame.Node] = link
+		}
+	}
+	l.cluster.mu.Unlock()
+
+	l.cluster.server.debugLogger.Printf("Cluster peer linked. node: %s, peer: %s", frame.Node, cli.RemoteAddr())
+}
+
+// ======== ======== Cluster frame codec ======== ========
+
+type clusterCodec struct{}
+
+func (clusterCodec) Encode(_ context.Context, message interface{}, _ *Session) ([]byte, error) {
+	return json.Marshal(message)
+}
+
+func (clusterCodec) Decode(_ context.Context, bytes []byte, _ *Session) (interface{}, error) {
+	frame := &clusterFrame{}
+	err := json.Unmarshal(bytes, frame)
+	return frame, err
+}
+
+type clusterClientCodec struct{}
+
+func (clusterClientCodec) Encode(_ context.Context, message interface{}, _ *TCPClient) ([]byte, error) {
+	return json.Marshal(message)
+}
+
+func (clusterClientCodec) Decode(_ context.Context, bytes []byte, _ *TCPClient) (interface{}, error) {
+	frame := &clusterFrame{}
+	err := json.Unmarshal(bytes, frame)
+	return frame, err
+}
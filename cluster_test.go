@@ -0,0 +1,109 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestClusterNode(t *testing.T, nodeID string, addr string, linkAddr string, peer string) (*TCPServer, *Cluster) {
+	server := NewTCPServer(addr).
+		RegisterMessageListener(&TestExampleServerMessageListener{}).
+		SetDebugMode(false)
+
+	cluster, err := NewCluster(nodeID, server, linkAddr).
+		AddPeer(peer).
+		SetRetryInterval(50 * time.Millisecond).
+		Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return server, cluster
+}
+
+func TestClusterFanOut(t *testing.T) {
+	serverA, clusterA := newTestClusterNode(t, "node-a", "127.0.0.1:18835", "127.0.0.1:19835", "127.0.0.1:19836")
+	defer serverA.Stop()
+	defer clusterA.Stop()
+	serverB, clusterB := newTestClusterNode(t, "node-b", "127.0.0.1:18836", "127.0.0.1:19836", "127.0.0.1:19835")
+	defer serverB.Stop()
+	defer clusterB.Stop()
+
+	// A client on node B.
+	received := testChanClientListener{received: make(chan interface{}, 4)}
+	client, err := NewTcpClient("127.0.0.1:18836").
+		RegisterMessageListener(received).
+		SetDebugMode(false).
+		Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Hangup("Test done.")
+	_ = client.Subscribe("room.*")
+
+	// Wait the session on B located by A, and the topic subscribed.
+	var sID string
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		for k := range serverB.Sessions() {
+			sID = k
+		}
+		node, located := clusterA.Locate(sID)
+		if sID != "" && located && node == "node-b" && len(serverB.Subscriptions(serverB.Sessions()[sID])) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("session on node B not located by node A")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	expect := func(want string) {
+		select {
+		case m := <-received.received:
+			if m != want {
+				t.Fatalf("expect %q, got %v", want, m)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("%q not received", want)
+		}
+	}
+
+	if err := clusterA.SendToSession(sID, "direct from A"); err != nil {
+		t.Fatal(err)
+	}
+	expect("direct from A")
+
+	if err := clusterA.Broadcast("broadcast from A"); err != nil {
+		t.Fatal(err)
+	}
+	expect("broadcast from A")
+
+	if err := clusterA.Publish("room.1", "publish from A"); err != nil {
+		t.Fatal(err)
+	}
+	expect("publish from A")
+
+	// Session closed, location removed on A.
+	client.Hangup("Leave.")
+	deadline = time.Now().Add(5 * time.Second)
+	for {
+		if _, located := clusterA.Locate(sID); !located {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("closed session still located")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// Stop twice, the deferred Stop not panic.
+	clusterB.Stop()
+	clusterB.Stop()
+}
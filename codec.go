@@ -7,6 +7,7 @@ package gosocket
 import "context"
 
 // Codec
+// - Decode runs on the session read goroutine, or the shared loop goroutine in event loop mode. Never block.
 type Codec interface {
 	// Encode body to bytes
 	Encode(ctx context.Context, message interface{}, session *Session) ([]byte, error)
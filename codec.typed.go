@@ -0,0 +1,90 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+//go:build go1.18
+// +build go1.18
+
+package gosocket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// TypedCodec server codec with message types. In: client -> server, Out: server -> client.
+type TypedCodec[In, Out any] interface {
+	// Encode body to bytes
+	Encode(ctx context.Context, message Out, session *Session) ([]byte, error)
+	// Decode from bytes
+	Decode(ctx context.Context, bytes []byte, session *Session) (In, error)
+}
+
+// TypedClientCodec client codec with message types. In: server -> client, Out: client -> server.
+type TypedClientCodec[In, Out any] interface {
+	// Encode body to bytes
+	Encode(ctx context.Context, message Out, cli *TCPClient) ([]byte, error)
+	// Decode from bytes
+	Decode(ctx context.Context, bytes []byte, cli *TCPClient) (In, error)
+}
+
+// typedCodec adapt TypedCodec to Codec
+type typedCodec[In, Out any] struct {
+	codec TypedCodec[In, Out]
+}
+
+func (c typedCodec[In, Out]) Encode(ctx context.Context, message interface{}, session *Session) ([]byte, error) {
+	m, ok := message.(Out)
+	if !ok {
+		return nil, fmt.Errorf("Message type %T is not %T. ", message, *new(Out))
+	}
+	return c.codec.Encode(ctx, m, session)
+}
+
+func (c typedCodec[In, Out]) Decode(ctx context.Context, bytes []byte, session *Session) (interface{}, error) {
+	return c.codec.Decode(ctx, bytes, session)
+}
+
+// typedClientCodec adapt TypedClientCodec to ClientCodec
+type typedClientCodec[In, Out any] struct {
+	codec TypedClientCodec[In, Out]
+}
+
+func (c typedClientCodec[In, Out]) Encode(ctx context.Context, message interface{}, cli *TCPClient) ([]byte, error) {
+	m, ok := message.(Out)
+	if !ok {
+		return nil, fmt.Errorf("Message type %T is not %T. ", message, *new(Out))
+	}
+	return c.codec.Encode(ctx, m, cli)
+}
+
+func (c typedClientCodec[In, Out]) Decode(ctx context.Context, bytes []byte, cli *TCPClient) (interface{}, error) {
+	return c.codec.Decode(ctx, bytes, cli)
+}
+
+// JSONCodec TypedCodec by encoding/json
+type JSONCodec[In, Out any] struct{}
+
+func (JSONCodec[In, Out]) Encode(_ context.Context, message Out, _ *Session) ([]byte, error) {
+	return json.Marshal(message)
+}
+
+func (JSONCodec[In, Out]) Decode(_ context.Context, bytes []byte, _ *Session) (In, error) {
+	var m In
+	err := json.Unmarshal(bytes, &m)
+	return m, err
+}
+
+// JSONClientCodec TypedClientCodec by encoding/json
+type JSONClientCodec[In, Out any] struct{}
+
+func (JSONClientCodec[In, Out]) Encode(_ context.Context, message Out, _ *TCPClient) ([]byte, error) {
+	return json.Marshal(message)
+}
+
+func (JSONClientCodec[In, Out]) Decode(_ context.Context, bytes []byte, _ *TCPClient) (In, error) {
+	var m In
+	err := json.Unmarshal(bytes, &m)
+	return m, err
+}
@@ -27,11 +27,41 @@ const (
 	sessionDefaultReadDeadline  = 5 * time.Second  // Default read deadline
 	sessionDefaultWriteDeadline = 5 * time.Second  // Default Write deadline
 	sessionDefaultHeartbeat     = 13 * time.Second // Default keepalive heart beat
+	defaultMaxMissedPongs       = 0                // Default max heartbeat pings without pong, then close (0: disable)
+)
+
+// Heartbeat timing wheel const. Tick 10ms, 256 slots per level, 4 levels span over 500 days.
+const (
+	timeWheelTick   = 10 * time.Millisecond
+	timeWheelSlots  = 256
+	timeWheelLevels = 4
+)
+
+// Handshake const
+const (
+	defaultHandshakeTimeout = 10 * time.Second // Default handshake (authenticate) timeout
+)
+
+// Worker pool const
+const (
+	defaultWorkerQueueSize = 64 // Default queue size of each worker
+)
+
+// Session close reasons of the server
+const (
+	ReasonIdleTimeout      = "Idle timeout."      // No application message sent or received in the idle timeout
+	ReasonHeartbeatTimeout = "Heartbeat timeout." // Max missed pongs exceeded
+)
+
+// Client auto reconnect const
+const (
+	clientMaxReconnectInterval = 30 * time.Second // Max interval between reconnect attempts, see TCPClient.SetAutoReconnect
 )
 
 // Send message channel const
 const (
 	defaultSendChanelCacheSize = 16
+	maxWriteBatch              = 64 // Max queued messages coalesced into one vectored write
 )
 
 // Heartbeat cmd
@@ -39,3 +69,13 @@ const (
 	HeartbeatCmdPing byte = 0
 	HeartbeatCmdPong byte = 1
 )
+
+// Control cmd, share the heartbeat cmd byte space. Body: [cmd 8bit][payload]
+// - 0..15 reserved for the built-in, ControlCmdUserMin..255 for custom, see RegisterControlHandler
+const (
+	ControlCmdSubscribe   byte = 2 // payload: topic pattern
+	ControlCmdUnsubscribe byte = 3 // payload: topic pattern
+	ControlCmdClose       byte = 4 // payload: [close code 16bit][reason], see CloseFrame
+
+	ControlCmdUserMin byte = 16 // The first custom cmd
+)
@@ -0,0 +1,131 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ControlHandler handle a custom control command received from client.
+// - Control packets go out of band: not through Codec, MessageListener, rate limit or worker pool.
+// - Runs on the session read goroutine, or the shared loop goroutine in event loop mode. Keep it short, never block.
+// - payload aliases the packet body. With TCPServer.SetBufferPooling the body is pooled, the payload is
+// invalid after OnControl returned: copy it to keep.
+type ControlHandler interface {
+	OnControl(session *Session, cmd byte, payload []byte)
+}
+
+// ControlHandlerFunc adapt a func to ControlHandler
+type ControlHandlerFunc func(session *Session, cmd byte, payload []byte)
+
+func (f ControlHandlerFunc) OnControl(session *Session, cmd byte, payload []byte) {
+	f(session, cmd, payload)
+}
+
+// ClientControlHandler handle a custom control command received from server. see ControlHandler
+type ClientControlHandler interface {
+	OnControl(cli *TCPClient, cmd byte, payload []byte)
+}
+
+// ClientControlHandlerFunc adapt a func to ClientControlHandler
+type ClientControlHandlerFunc func(cli *TCPClient, cmd byte, payload []byte)
+
+func (f ClientControlHandlerFunc) OnControl(cli *TCPClient, cmd byte, payload []byte) {
+	f(cli, cmd, payload)
+}
+
+// checkControlCmd custom cmd must not use the reserved range
+func checkControlCmd(cmd byte) error {
+	if cmd < ControlCmdUserMin {
+		return fmt.Errorf("Control cmd %d reserved, custom cmd must >= %d. ", cmd, ControlCmdUserMin)
+	}
+	return nil
+}
+
+// RegisterControlHandler handle the custom control cmd sent by client. cmd must >= ControlCmdUserMin.
+func (ts *TCPServer) RegisterControlHandler(cmd byte, handler ControlHandler) *TCPServer {
+	ts.checkPreparingStatus()
+	if err := checkControlCmd(cmd); err != nil {
+		ts.logger.Panic(err)
+	}
+	ts.controlHandlers[cmd] = handler
+	return ts
+}
+
+func (ts *TCPServer) onControlCmd(s *Session, cmd byte, payload []byte) {
+	handler, ok := ts.controlHandlers[cmd]
+	if !ok {
+		ts.debugLogger.Printf("Control cmd no handler. sID: %s, cmd: %d", s.sID, cmd)
+		return
+	}
+	ts.safeCall(s, "ControlHandler.OnControl", func() { handler.OnControl(s, cmd, payload) })
+}
+
+// SendControl send a custom control packet to client. cmd must >= ControlCmdUserMin.
+func (s *Session) SendControl(cmd byte, payload []byte) error {
+	if err := checkControlCmd(cmd); err != nil {
+		return err
+	}
+	if uint32(1+len(payload)) > s.serRef.maxPacketBodyLen {
+		return errors.New("Control payload exceed max packet body length. ")
+	}
+
+	select {
+	case <-s.closed:
+		return errors.New("Session closed. ")
+	default:
+	}
+
+	select {
+	case s.ctrlSendChan <- NewControlPacket(cmd, payload):
+		return nil
+	case <-s.closed:
+		return errors.New("Session closed. ")
+	}
+}
+
+// RegisterControlHandler handle the custom control cmd sent by server. cmd must >= ControlCmdUserMin.
+func (cli *TCPClient) RegisterControlHandler(cmd byte, handler ClientControlHandler) *TCPClient {
+	cli.checkPreparingStatus()
+	if err := checkControlCmd(cmd); err != nil {
+		cli.logger.Panic(err)
+	}
+	cli.controlHandlers[cmd] = handler
+	return cli
+}
+
+func (cli *TCPClient) onControlCmd(cmd byte, payload []byte) {
+	handler, ok := cli.controlHandlers[cmd]
+	if !ok {
+		cli.debugLogger.Printf("Client %s control cmd no handler. cmd: %d", cli.name, cmd)
+		return
+	}
+	cli.safeCall("ClientControlHandler.OnControl", func() { handler.OnControl(cli, cmd, payload) })
+}
+
+// SendControl send a custom control packet to server. cmd must >= ControlCmdUserMin.
+func (cli *TCPClient) SendControl(cmd byte, payload []byte) error {
+	if err := checkControlCmd(cmd); err != nil {
+		return err
+	}
+	if uint32(1+len(payload)) > cli.maxPacketBodyLen {
+		return errors.New("Control payload exceed max packet body length. ")
+	}
+
+	cli.mu.Lock()
+	status, done := cli.status, cli.connDone
+	cli.mu.Unlock()
+
+	if status != Running {
+		return errors.New("Client " + status)
+	}
+
+	// Not hold cli.mu while queuing, the disconnect need it to close the connection
+	if !cli.queueControl(NewControlPacket(cmd, payload), done) {
+		return errors.New("Client " + Stop)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"hash/adler32"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestControlHandler(t *testing.T) {
+	const cmdEcho = ControlCmdUserMin + 1
+	const cmdPanic = ControlCmdUserMin + 2
+
+	echoed := make(chan string, 1)
+	sessions := make(chan *Session, 1)
+
+	server, err := NewTCPServer("127.0.0.1:18846").
+		RegisterMessageListener(&TestExampleServerMessageListener{}).
+		RegisterControlHandler(cmdEcho, ControlHandlerFunc(func(s *Session, cmd byte, payload []byte) {
+			_ = s.SendControl(cmd, append([]byte("echo:"), payload...))
+			select {
+			case sessions <- s:
+			default:
+			}
+		})).
+		RegisterControlHandler(cmdPanic, ControlHandlerFunc(func(s *Session, cmd byte, _ []byte) {
+			_ = s.SendControl(cmd, nil)
+		})).
+		SetDebugMode(false).
+		Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	client, err := NewTcpClient("127.0.0.1:18846").
+		RegisterMessageListener(&TestExampleClientListener{}).
+		RegisterControlHandler(cmdEcho, ClientControlHandlerFunc(func(_ *TCPClient, _ byte, payload []byte) {
+			echoed <- string(payload)
+		})).
+		RegisterControlHandler(cmdPanic, ClientControlHandlerFunc(func(_ *TCPClient, _ byte, _ []byte) {
+			panic("client control handler panic")
+		})).
+		SetDebugMode(false).
+		Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Hangup("Test done.")
+
+	if err := client.SendControl(HeartbeatCmdPing, nil); err == nil {
+		t.Fatal("expect reserved cmd refused")
+	}
+	// The client handler panic recovered, the connection keeps running
+	if err := client.SendControl(cmdPanic, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SendControl(cmdEcho, []byte("hi")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case payload := <-echoed:
+		if payload != "echo:hi" {
+			t.Fatalf("expect echo:hi, got %s", payload)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("expect control echoed")
+	}
+
+	// Closed session refuse, not block on the queue nobody drains
+	s := <-sessions
+	s.CloseSession("Bye.")
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		for i := 0; i <= defaultSendChanelCacheSize && err == nil; i++ {
+			err = s.SendControl(cmdEcho, nil)
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expect closed session refused")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("SendControl blocked on closed session")
+	}
+}
+
+func TestClientEmptyControlPacket(t *testing.T) {
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	client, err := NewTcpClient(ln.Addr().String()).
+		RegisterMessageListener(testChanClientListener{received: make(chan interface{}, 1)}).
+		SetDebugMode(false).
+		Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Hangup("Bye.")
+
+	conn, err := ln.AcceptTCP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// An empty heartbeat body skipped, the ping after still answered
+	empty := NewPacket(PacketHeartbeatVersion, 0, []byte{}, adler32.Checksum(nil))
+	if err := writePacket(conn, empty); err != nil {
+		t.Fatal(err)
+	}
+	if err := writePacket(conn, NewControlPacket(HeartbeatCmdPing, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	pac, err := readPacket(conn, defaultMaxPacketBodyLength)
+	if err != nil {
+		t.Fatalf("expect pong, got %v", err)
+	}
+	if pac.ver != PacketHeartbeatVersion || len(pac.body) == 0 || pac.body[0] != HeartbeatCmdPong {
+		t.Fatalf("expect pong, got ver %d body %v", pac.ver, pac.body)
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+)
+
+// DispatchPolicy what happens when the worker of a session is saturated
+type DispatchPolicy int
+
+const (
+	// DispatchWait block the session read goroutine until the worker queue has room. The event loop stop reading the session instead.
+	DispatchWait DispatchPolicy = iota
+	// DispatchShed drop the message
+	DispatchShed
+)
+
+// dispatcher bounded worker pool run MessageListener off the read goroutine.
+// - Messages of one session always go to the same worker, so they keep in order.
+type dispatcher struct {
+	queues []chan dispatchJob
+	policy DispatchPolicy
+	quit   chan struct{}
+	wg     sync.WaitGroup
+}
+
+type dispatchJob struct {
+	ctx     context.Context
+	fn      func()
+	release func() // Called instead of fn if the job discarded, nil if nothing to release
+}
+
+// discard drop the job without running fn
+func (j dispatchJob) discard() {
+	if j.release != nil {
+		j.release()
+	}
+}
+
+func newDispatcher(workers int, queueSize int, policy DispatchPolicy) *dispatcher {
+	if workers < 1 {
+		workers = 1
+	}
+
+	d := &dispatcher{
+		queues: make([]chan dispatchJob, workers),
+		policy: policy,
+		quit:   make(chan struct{}),
+	}
+	for i := range d.queues {
+		d.queues[i] = make(chan dispatchJob, queueSize)
+	}
+	return d
+}
+
+func (d *dispatcher) start() {
+	for _, q := range d.queues {
+		d.wg.Add(1)
+		go d.work(q)
+	}
+}
+
+// stop the workers, the jobs still queued are discarded
+func (d *dispatcher) stop() {
+	close(d.quit)
+	d.wg.Wait()
+
+	for _, q := range d.queues {
+		drainJobs(q)
+	}
+}
+
+func (d *dispatcher) stopped() bool {
+	select {
+	case <-d.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+func drainJobs(q chan dispatchJob) {
+	for {
+		select {
+		case job := <-q:
+			job.discard()
+		default:
+			return
+		}
+	}
+}
+
+func (d *dispatcher) work(q chan dispatchJob) {
+	defer d.wg.Done()
+
+	for {
+		select {
+		case <-d.quit:
+			return
+		case job := <-q:
+			// Session closed or stopping, discard the messages still queued.
+			if job.ctx.Err() != nil || d.stopped() {
+				job.discard()
+				continue
+			}
+			job.fn()
+		}
+	}
+}
+
+// dispatch queue fn to the worker of key. Return false if shed or ctx done.
+// - release is called instead of fn if the job queued but discarded, as the session closed or the pool stopped.
+// The caller release itself if false returned.
+func (d *dispatcher) dispatch(ctx context.Context, key string, fn func(), release func()) bool {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	q := d.queues[h.Sum32()%uint32(len(d.queues))]
+
+	job := dispatchJob{ctx: ctx, fn: fn, release: release}
+
+	if d.policy == DispatchShed {
+		select {
+		case q <- job:
+			return true
+		default:
+			return false
+		}
+	}
+
+	select {
+	case q <- job:
+		return true
+	default:
+	}
+
+	// The event loop not wait, the job is held and the loop stop reading the session until queued
+	if reader, onLoop := loopReaderFromContext(ctx); onLoop {
+		reader.hold(q, job)
+		return true
+	}
+
+	select {
+	case q <- job:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-d.quit:
+		return false
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDispatcherKeepsSessionOrder(t *testing.T) {
+	d := newDispatcher(4, 8, DispatchWait)
+	d.start()
+	defer d.stop()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	got := make(map[string][]int)
+
+	for i := 0; i < 300; i++ {
+		key := fmt.Sprint("session-", i%3)
+		seq := i
+
+		wg.Add(1)
+		d.dispatch(context.Background(), key, func() {
+			mu.Lock()
+			got[key] = append(got[key], seq)
+			mu.Unlock()
+			wg.Done()
+		}, nil)
+	}
+	wg.Wait()
+
+	for key, seqs := range got {
+		for i := 1; i < len(seqs); i++ {
+			if seqs[i] < seqs[i-1] {
+				t.Fatalf("%s out of order: %v", key, seqs)
+			}
+		}
+	}
+}
+
+func TestDispatcherShed(t *testing.T) {
+	d := newDispatcher(1, 1, DispatchShed)
+	d.start()
+	defer d.stop()
+
+	block := make(chan struct{})
+	running := make(chan struct{})
+	d.dispatch(context.Background(), "s", func() { close(running); <-block }, nil)
+	<-running
+
+	if !d.dispatch(context.Background(), "s", func() {}, nil) {
+		t.Fatal("expect queued while queue has room")
+	}
+	if d.dispatch(context.Background(), "s", func() {}, nil) {
+		t.Fatal("expect shed while queue full")
+	}
+	close(block)
+}
+
+func TestDispatcherRelease(t *testing.T) {
+	d := newDispatcher(1, 4, DispatchWait)
+
+	var released int32
+	release := func() { atomic.AddInt32(&released, 1) }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d.dispatch(ctx, "s", func() { t.Error("discarded job run") }, release)
+	d.dispatch(context.Background(), "s", func() {}, release)
+
+	// The session closed: its job discarded by the worker
+	cancel()
+	d.start()
+	for i := 0; atomic.LoadInt32(&released) < 1 && i < 300; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := atomic.LoadInt32(&released); got != 1 {
+		t.Fatalf("expect the canceled job released, got %d", got)
+	}
+
+	// Still queued at stop: drained and released
+	block := make(chan struct{})
+	running := make(chan struct{})
+	d.dispatch(context.Background(), "s", func() { close(running); <-block }, nil)
+	<-running
+	d.dispatch(context.Background(), "s", func() { t.Error("drained job run") }, release)
+	go func() { time.Sleep(50 * time.Millisecond); close(block) }()
+	d.stop()
+
+	if got := atomic.LoadInt32(&released); got != 2 {
+		t.Fatalf("expect the drained job released, got %d", got)
+	}
+}
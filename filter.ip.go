@@ -0,0 +1,116 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"net"
+	"strings"
+	"sync"
+)
+
+// IPFilter CIDR allow/deny rules, checked on connection accept before session create.
+// - Deny rules take precedence over allow rules.
+// - If any allow rule exists, only the matched IPs are permitted.
+// - Safe for update while the server running.
+type IPFilter struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+	mu    sync.RWMutex
+}
+
+func NewIPFilter() *IPFilter {
+	return &IPFilter{}
+}
+
+// Allow add allow rules. Like: "10.0.0.0/8", "2001:db8::/32", "192.168.1.10"
+func (f *IPFilter) Allow(cidrs ...string) error {
+	nets, err := parseCIDRs(cidrs)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	f.allow = append(f.allow, nets...)
+	f.mu.Unlock()
+
+	return nil
+}
+
+// Deny add deny rules. Like: "10.0.0.0/8", "2001:db8::/32", "192.168.1.10"
+func (f *IPFilter) Deny(cidrs ...string) error {
+	nets, err := parseCIDRs(cidrs)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	f.deny = append(f.deny, nets...)
+	f.mu.Unlock()
+
+	return nil
+}
+
+// SetRules replace all the rules at once.
+func (f *IPFilter) SetRules(allow []string, deny []string) error {
+	allowNets, err := parseCIDRs(allow)
+	if err != nil {
+		return err
+	}
+	denyNets, err := parseCIDRs(deny)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	f.allow, f.deny = allowNets, denyNets
+	f.mu.Unlock()
+
+	return nil
+}
+
+// Permitted return the ip is permitted by the rules
+func (f *IPFilter) Permitted(ip net.IP) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	for _, n := range f.deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+
+	if len(f.allow) == 0 {
+		return true
+	}
+	for _, n := range f.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCIDRs parse CIDR rules, a single IP is treated as /32 (IPv4) or /128 (IPv6).
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+
+	for _, c := range cidrs {
+		if !strings.Contains(c, "/") {
+			if ip := net.ParseIP(c); ip != nil && ip.To4() != nil {
+				c += "/32"
+			} else {
+				c += "/128"
+			}
+		}
+
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+
+	return nets, nil
+}
@@ -0,0 +1,52 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"net"
+	"testing"
+)
+
+func TestIPFilter(t *testing.T) {
+	f := NewIPFilter()
+
+	if !f.Permitted(net.ParseIP("203.0.113.7")) {
+		t.Fatal("expect permit all without rules")
+	}
+
+	if err := f.Allow("10.0.0.0/8", "2001:db8::/32"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Deny("10.1.0.0/16", "10.2.3.4"); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]bool{
+		"10.9.9.9":    true,
+		"10.1.2.3":    false, // Deny wins
+		"10.2.3.4":    false,
+		"10.2.3.5":    true,
+		"2001:db8::1": true,
+		"192.168.1.1": false, // Not in allow
+		"2001:db9::1": false,
+	}
+	for ip, want := range cases {
+		if got := f.Permitted(net.ParseIP(ip)); got != want {
+			t.Errorf("%s: expect %v, got %v", ip, want, got)
+		}
+	}
+
+	// Runtime replace
+	if err := f.SetRules(nil, []string{"192.168.0.0/16"}); err != nil {
+		t.Fatal(err)
+	}
+	if !f.Permitted(net.ParseIP("10.1.2.3")) || f.Permitted(net.ParseIP("192.168.1.1")) {
+		t.Fatal("expect rules replaced")
+	}
+
+	if err := f.Allow("not-a-cidr"); err == nil {
+		t.Fatal("expect parse error")
+	}
+}
@@ -19,76 +19,181 @@ type defaultConnectHandler struct {
 
 func (d defaultConnectHandler) OnConnect(ctx context.Context, conn *net.TCPConn, tcpSer *TCPServer) {
 	s := NewSession(conn, tcpSer.defaultReadDeadline, tcpSer.defaultWriteDeadline, tcpSer.defaultHeartbeat, tcpSer)
-	tcpSer.sessions[s.sID] = s
+
+	if tcpSer.authenticator != nil {
+		if err := serverHandshake(ctx, s, tcpSer); err != nil {
+			tcpSer.logger.Printf("Handshake failure. client: %s, %v", conn.RemoteAddr().String(), err)
+			tcpSer.notifyRejected(conn.RemoteAddr().String(), RejectHandshake)
+			return
+		}
+		tcpSer.debugLogger.Printf("Handshake accepted. sID: %s, identity: %v", s.sID, s.identity)
+	}
+
+	tcpSer.addSession(s)
+	if userID, ok := s.identity.(string); ok && userID != "" {
+		_ = tcpSer.BindUser(s, userID)
+	}
 	tcpSer.debugLogger.Printf("Session create. sID: %s, client: %s", s.sID, s.conn.RemoteAddr().String())
 
 	if tcpSer.sessionListener != nil {
-		tcpSer.sessionListener.OnSessionCreate(s)
+		tcpSer.safeCall(s, "SessionListener.OnSessionCreate", func() { tcpSer.sessionListener.OnSessionCreate(s) })
+	}
+	for _, observer := range tcpSer.sessionObservers {
+		observer.OnSessionCreate(s)
 	}
 
 	ctx2, cancel := context.WithCancel(ctx)
 
-	go d.writeGo(ctx2, s, tcpSer)
-	go d.readGo(ctx2, s, tcpSer)
-
-	if <-s.closeSign {
-		cancel()
-		s.UpdateLastActive()
+	if tcpSer.reactor != nil {
+		// Event loop mode: the loop read, this goroutine write until the session closed
+		lc, err := tcpSer.reactor.add(ctx2, s)
+		if err != nil {
+			s.CloseSessionWithCode(CloseInternalError, fmt.Sprint("Event loop add error. ", err))
+		}
+		if !d.writeGo(ctx2, s, tcpSer, s.closeSign) {
+			<-s.closeSign // Server stopping, it close the sessions
+		}
+		if lc != nil {
+			tcpSer.reactor.remove(lc)
+		}
+	} else {
+		go d.writeGo(ctx2, s, tcpSer, nil)
+		go d.readGo(ctx2, s, tcpSer)
+		<-s.closeSign
+	}
 
-		delete(tcpSer.sessions, s.sID)
+	cancel()
+	s.UpdateLastActive()
 
-		if tcpSer.sessionListener != nil {
-			tcpSer.sessionListener.OnSessionClose(s)
+	if frame := s.CloseFrame(); s.sendClose {
+		if err := writeCloseFrame(s.conn, frame, s.writeDeadline); err != nil {
+			tcpSer.debugLogger.Printf("Close frame send error. sID: %s, %v", s.sID, err)
 		}
+	}
+
+	tcpSer.removeSession(s)
+	tcpSer.topics.removeSession(s)
+	tcpSer.UnbindUser(s)
 
-		// Conn will close after return.
+	if tcpSer.sessionListener != nil {
+		tcpSer.safeCall(s, "SessionListener.OnSessionClose", func() { tcpSer.sessionListener.OnSessionClose(s) })
+	}
+	for _, observer := range tcpSer.sessionObservers {
+		observer.OnSessionClose(s)
 	}
+
+	// Conn will close after return.
 }
 
-func (d defaultConnectHandler) writeGo(ctx context.Context, s *Session, tcpSer *TCPServer) {
+// writeGo write the messages, control packets and heartbeat until ctx done, or closed signaled (event loop mode).
+// - Return true if returned on the closed signal.
+func (d defaultConnectHandler) writeGo(ctx context.Context, s *Session, tcpSer *TCPServer, closed <-chan bool) bool {
+	// Heartbeat and idle check scheduled on the server timing wheel, not a timer per loop
+	heartbeat := tcpSer.timeWheel.newTicker(s.heartbeat)
+	defer heartbeat.stop()
+
+	batch := make([]*Packet, 0, maxWriteBatch)
+
 	for {
 		select {
 
 		// Cancel
 		case <-ctx.Done():
 			tcpSer.debugLogger.Printf("Session Write Done. sID: %s.", s.sID)
-			return
+			return false
+		case <-closed:
+			tcpSer.debugLogger.Printf("Session Write Done. sID: %s.", s.sID)
+			return true
 
-		// Message write
+		// Message write. Coalesce the messages already queued into one write.
 		case msg := <-s.msgSendChan:
+			pacs := batch[:0]
+			for {
+				pac, ok := d.messagePacket(ctx, msg, s, tcpSer)
+				if !ok {
+					return false
+				}
+				if pac != nil {
+					pacs = append(pacs, pac)
+				}
 
-			data, err := tcpSer.codec.Encode(ctx, msg, s)
-			if err != nil {
-				s.CloseSession(fmt.Sprint("Encode data error.", err))
-				return
+				if len(pacs) >= maxWriteBatch || len(s.msgSendChan) == 0 {
+					break
+				}
+				msg = <-s.msgSendChan // Only this goroutine receive, not block
 			}
-
-			size := uint32(len(data))
-			if size > tcpSer.maxPacketBodyLen {
-				s.CloseSession(fmt.Sprintf("Send packet size(%d) exceed max limit. ", size))
-				return
+			if len(pacs) == 0 {
+				continue
 			}
 
-			pac := NewPacket(PacketVersion, size, data, adler32.Checksum(data))
+			sendPackets(ctx, tcpSer.packetHandler, pacs, s)
+			s.updateLastMessage()
 
+			for i := range pacs { // Not hold the bodies until the next batch
+				pacs[i] = nil
+			}
+
+		// Control packet write
+		case pac := <-s.ctrlSendChan:
 			tcpSer.packetHandler.PacketSend(ctx, pac, s)
 
 		// Heartbeat
-		case <-time.After(s.heartbeat):
-			if s.lastActive.Add(s.heartbeat).After(time.Now()) {
+		case <-heartbeat.C:
+			if idleTimeout := s.IdleTimeout(); idleTimeout > 0 && s.IdleTime() > idleTimeout {
+				s.CloseSessionWithCode(CloseIdleTimeout, ReasonIdleTimeout)
+				return false
+			}
+
+			// Active in the last period. One tick slack, the last ping was sent just after the last tick.
+			if s.LastActive().Add(s.heartbeat - timeWheelTick).After(time.Now()) {
 				continue
 			}
 
+			if maxMissed := s.MaxMissedPongs(); maxMissed > 0 && s.rtt.missedPongs() >= maxMissed {
+				tcpSer.notifyHeartbeat(s, HeartbeatEvent{Type: HeartbeatTimeout, Missed: s.rtt.missedPongs()})
+				s.CloseSessionWithCode(CloseHeartbeatTimeout, ReasonHeartbeatTimeout)
+				return false
+			}
+
 			// Heartbeat can represent 256 instructions. 0: ping; 1: pong
-			pac := NewHeartbeatPacket(HeartbeatCmdPing)
+			pac, missed := s.rtt.heartbeatPing()
 
 			tcpSer.packetHandler.PacketSend(ctx, pac, s)
-			tcpSer.debugLogger.Printf("Heartbeat ping sent. sID: %s, checksum: %d", s.sID, pac.checksum)
+			tcpSer.debugLogger.Printf("Heartbeat ping sent. sID: %s, checksum: %d, missed: %d", s.sID, pac.checksum, missed)
+			tcpSer.notifyHeartbeat(s, HeartbeatEvent{Type: HeartbeatPingSent, Missed: missed})
 		}
 	}
 }
 
+// messagePacket encode the message to a packet. Return nil if skipped, false if the session closed.
+func (d defaultConnectHandler) messagePacket(ctx context.Context, msg interface{}, s *Session, tcpSer *TCPServer) (*Packet, bool) {
+	var data []byte
+	var err error
+	if encoded, ok := msg.(encodedMessage); ok {
+		data = encoded
+	} else if !tcpSer.safeCall(s, "Codec.Encode", func() { data, err = tcpSer.codec.Encode(ctx, msg, s) }) {
+		return nil, true
+	}
+	if err != nil {
+		s.CloseSessionWithCode(CloseInternalError, fmt.Sprint("Encode data error.", err))
+		return nil, false
+	}
+
+	size := uint32(len(data))
+	if size > tcpSer.maxPacketBodyLen {
+		s.CloseSessionWithCode(CloseMessageTooBig, fmt.Sprintf("Send packet size(%d) exceed max limit. ", size))
+		return nil, false
+	}
+
+	return NewPacket(PacketVersion, size, data, adler32.Checksum(data)), true
+}
+
 func (d defaultConnectHandler) readGo(ctx context.Context, s *Session, tcpSer *TCPServer) {
+	// Header and checksum buffers reused by every packet
+	var verBuf [1]byte
+	sizeBuf := make([]byte, 4)
+	checksumBuf := make([]byte, 4)
+
 	for {
 		select {
 
@@ -100,88 +205,177 @@ func (d defaultConnectHandler) readGo(ctx context.Context, s *Session, tcpSer *T
 		// Message read
 		default:
 			if err := s.conn.SetReadDeadline(time.Now().Add(s.readDeadline)); err != nil {
-				s.CloseSession(fmt.Sprint("Set ReadDeadline error.", err))
+				s.CloseSessionWithCode(CloseAbnormal, fmt.Sprint("Set ReadDeadline error.", err))
 				return
 			}
 
 			// Read Version
-			var verBuf [1]byte
 			if _, err := s.conn.Read(verBuf[:]); err != nil {
 				if timeoutErr, ok := err.(*net.OpError); ok && timeoutErr.Err.Error() == ErrTimeout.Error() {
 					//tcpSer.debugLogger.Printf("Session %s read continue.", s.SID())
 					continue
 				}
 				if err.Error() == io.EOF.Error() {
-					s.CloseSession(fmt.Sprint("Session EOF. ", err))
+					s.CloseSessionWithCode(CloseAbnormal, fmt.Sprint("Session EOF. ", err))
 				} else {
-					s.CloseSession(fmt.Sprint("Read close. ", err))
+					s.CloseSessionWithCode(CloseAbnormal, fmt.Sprint("Read close. ", err))
 				}
 				return
 			}
 
 			// Unknown ver
 			if verBuf[0] != PacketVersion && verBuf[0] != PacketHeartbeatVersion {
-				s.CloseSession(fmt.Sprintf("Ver(%s) is wrong.", string(verBuf[0])))
+				s.CloseSessionWithCode(CloseProtocolError, fmt.Sprintf("Ver(%s) is wrong.", string(verBuf[0])))
 			}
 
-			// Read size
-			var sizeBuf = make([]byte, 4)
-			if i, err := s.conn.Read(sizeBuf); i < 4 || err != nil {
-				s.CloseSession(fmt.Sprint("Read packet size error.", err))
+			// Read size. Read the rest fully, the packets written in a batch may split over segments.
+			if i, err := io.ReadFull(s.conn, sizeBuf); i < 4 || err != nil {
+				s.CloseSessionWithCode(CloseAbnormal, fmt.Sprint("Read packet size error.", err))
 				return
 			}
 
 			// Read size check
 			size := binary.BigEndian.Uint32(sizeBuf)
 			if size > tcpSer.maxPacketBodyLen {
-				s.CloseSession(fmt.Sprintf("Recv packet size(%d) exceed max limit. ", size))
+				s.CloseSessionWithCode(CloseMessageTooBig, fmt.Sprintf("Recv packet size(%d) exceed max limit. ", size))
 				return
 			}
 
-			// Read body
-			var dataBuf = make([]byte, size) // data size + checksum len
-			if i, err := s.conn.Read(dataBuf); uint32(i) < size || err != nil {
-				s.CloseSession(fmt.Sprint("Read packet body error.", err))
+			// Read body. From the size-classed pool if enabled, released after handled.
+			var packet *Packet
+			if tcpSer.bufferPooling {
+				packet = newPooledPacket(verBuf[0], size)
+			} else {
+				packet = NewPacket(verBuf[0], size, make([]byte, size), 0)
+			}
+			if i, err := io.ReadFull(s.conn, packet.body); uint32(i) < size || err != nil {
+				s.CloseSessionWithCode(CloseAbnormal, fmt.Sprint("Read packet body error.", err))
 				return
 			}
 
 			// Read checksum
-			var checksumBuf = make([]byte, 4)
-			if i, err := s.conn.Read(checksumBuf); uint32(i) < 4 || err != nil {
-				s.CloseSession(fmt.Sprint("Read packet checksum error. ", err))
+			if i, err := io.ReadFull(s.conn, checksumBuf); uint32(i) < 4 || err != nil {
+				s.CloseSessionWithCode(CloseAbnormal, fmt.Sprint("Read packet checksum error. ", err))
 				return
 			}
 
 			// Checksum check
-			checksum := binary.BigEndian.Uint32(checksumBuf)
-			packet := NewPacket(verBuf[0], size, dataBuf, checksum)
+			packet.checksum = binary.BigEndian.Uint32(checksumBuf)
 			if !packet.Checksum() {
-				s.CloseSession(fmt.Sprintf("Checksum error. Check false. %d, except: %d", packet.checksum, adler32.Checksum(packet.body)))
+				s.CloseSessionWithCode(CloseProtocolError, fmt.Sprintf("Checksum error. Check false. %d, except: %d", packet.checksum, adler32.Checksum(packet.body)))
 				return
 			}
 
-			// Heartbeat or message receive
-			if verBuf[0] == PacketHeartbeatVersion { // Heartbeat
-				// Heartbeat can represent 256 instructions. 0: ping; 1: pong
-				// Check heartbeat body length right and cmd in 0 or 1.
-				if len(dataBuf) == 1 {
-					if dataBuf[0] == HeartbeatCmdPong { // Received heartbeat pong
-						tcpSer.debugLogger.Printf("Heartbeat pong received. sID: %s, checksum: %d", s.sID, checksum)
-					}
-					if dataBuf[0] == HeartbeatCmdPing { // Received heartbeat ping
-						tcpSer.debugLogger.Printf("Heartbeat ping received. sID: %s, checksum: %d", s.sID, checksum)
-						// Heartbeat can represent 256 instructions. 0: ping; 1: pong
-						pac := NewHeartbeatPacket(HeartbeatCmdPong)
-
-						tcpSer.packetHandler.PacketSend(ctx, pac, s)
-						tcpSer.debugLogger.Printf("Heartbeat pong sent. sID: %s, checksum: %d", s.sID, pac.checksum)
-					}
-				} else {
-					tcpSer.debugLogger.Printf("Heartbeat unknown cmd. sID: %s, cmd: %s, checksum: %d", s.sID, string(dataBuf), checksum)
+			ok := d.handlePacket(ctx, packet, s, tcpSer)
+			packet.Release()
+			if !ok {
+				return
+			}
+		}
+	}
+}
+
+// handlePacket handle the heartbeat, control or message packet received. Return false if the session closed.
+func (d defaultConnectHandler) handlePacket(ctx context.Context, packet *Packet, s *Session, tcpSer *TCPServer) bool {
+	dataBuf, checksum, size := packet.body, packet.checksum, packet.len
+
+	// Heartbeat or message receive
+	if packet.ver == PacketHeartbeatVersion { // Heartbeat
+		// Heartbeat can represent 256 instructions. 0: ping; 1: pong; 2..: control
+		// Body: [cmd 8bit][payload]
+		if len(dataBuf) == 0 {
+			tcpSer.debugLogger.Printf("Heartbeat empty cmd. sID: %s, checksum: %d", s.sID, checksum)
+			return true
+		}
+
+		switch cmd, payload := dataBuf[0], dataBuf[1:]; cmd {
+		case HeartbeatCmdPong: // Received heartbeat pong
+			rtt, _ := s.rtt.onPong(payload)
+			tcpSer.debugLogger.Printf("Heartbeat pong received. sID: %s, checksum: %d, rtt: %v", s.sID, checksum, rtt)
+			tcpSer.notifyHeartbeat(s, HeartbeatEvent{Type: HeartbeatPongReceived, RTT: rtt})
+		case HeartbeatCmdPing: // Received heartbeat ping
+			tcpSer.debugLogger.Printf("Heartbeat ping received. sID: %s, checksum: %d", s.sID, checksum)
+			// Heartbeat can represent 256 instructions. 0: ping; 1: pong. Echo the ping payload.
+			pac := NewControlPacket(HeartbeatCmdPong, payload)
+
+			if _, onLoop := loopReaderFromContext(ctx); onLoop {
+				// The loop not write, the session goroutine send it. Dropped if its queue full, the client ping again.
+				select {
+				case s.ctrlSendChan <- pac:
+				default:
+					tcpSer.debugLogger.Printf("Heartbeat pong dropped, control queue full. sID: %s", s.sID)
+					return true
 				}
-			} else { // Message receive
-				tcpSer.packetHandler.PacketReceived(ctx, packet, s)
+			} else {
+				tcpSer.packetHandler.PacketSend(ctx, pac, s)
+			}
+			tcpSer.debugLogger.Printf("Heartbeat pong sent. sID: %s, checksum: %d", s.sID, pac.checksum)
+		case ControlCmdSubscribe:
+			tcpSer.onSubscribeCmd(s, string(payload))
+		case ControlCmdUnsubscribe:
+			tcpSer.Unsubscribe(s, string(payload))
+			tcpSer.debugLogger.Printf("Unsubscribed. sID: %s, pattern: %s", s.sID, string(payload))
+		case ControlCmdClose: // Closed by client, no need to send close back
+			s.closeSession(decodeCloseFrame(payload), false)
+			return false
+		default:
+			if cmd >= ControlCmdUserMin {
+				tcpSer.onControlCmd(s, cmd, payload)
+				return true
+			}
+			tcpSer.debugLogger.Printf("Heartbeat unknown cmd. sID: %s, cmd: %d, checksum: %d", s.sID, cmd, checksum)
+		}
+		return true
+	}
+
+	// Message receive
+	if limiter := s.inboundLimiter(); limiter != nil {
+		wait, ok := limiter.take(int(size))
+
+		if !ok && limiter.limit.Policy == RateLimitClose {
+			s.CloseSessionWithCode(CloseRateLimited, fmt.Sprintf("Rate limit exceeded. size: %d", size))
+			return false
+		}
+		if !ok { // RateLimitDrop
+			tcpSer.debugLogger.Printf("Rate limit exceeded, message dropped. sID: %s, len: %d", s.sID, size)
+			return true
+		}
+		if wait > 0 { // RateLimitDelay
+			if reader, onLoop := loopReaderFromContext(ctx); onLoop {
+				reader.delay(packet, wait) // The loop not sleep, it stop reading the session instead
+				return true
+			}
+			select {
+			case <-ctx.Done():
+				return false
+			case <-time.After(wait):
 			}
 		}
 	}
+
+	d.receivePacket(ctx, packet, s, tcpSer)
+	return true
+}
+
+// receivePacket pass the message packet to the packet handler, decoded and delivered to the listener.
+func (d defaultConnectHandler) receivePacket(ctx context.Context, packet *Packet, s *Session, tcpSer *TCPServer) {
+	if packet.buf != nil { // Pooled, let the codec and listener Retain it
+		ctx = context.WithValue(ctx, packetContextKey{}, packet)
+	}
+	tcpSer.packetHandler.PacketReceived(ctx, packet, s)
+}
+
+// loopReader the event loop reading the session, found in the ctx of the packets it read. The loop must never block.
+// - delay: the RateLimitDelay packet, received after wait. The loop stop reading the session meanwhile.
+// - hold: the dispatch job the worker queue is full for, queued later. The loop stop reading the session meanwhile.
+type loopReader interface {
+	delay(packet *Packet, wait time.Duration)
+	hold(q chan dispatchJob, job dispatchJob)
+}
+
+type loopReaderKey struct{}
+
+func loopReaderFromContext(ctx context.Context) (loopReader, bool) {
+	reader, ok := ctx.Value(loopReaderKey{}).(loopReader)
+	return reader, ok
 }
@@ -22,12 +22,45 @@ type PacketHandler interface {
 	PacketSend(ctx context.Context, packet *Packet, session *Session)
 }
 
+// PacketBatchSender optional for a PacketHandler, write the packets coalesced by the write loop with one vectored write.
+// - A PacketHandler not implement it gets PacketSend per packet.
+type PacketBatchSender interface {
+	PacketSendBatch(ctx context.Context, packets []*Packet, session *Session)
+}
+
 // ClientPacketHandler
 type ClientPacketHandler interface {
 	PacketReceived(ctx context.Context, packet *Packet, cli *TCPClient)
 	PacketSend(ctx context.Context, packet *Packet, cli *TCPClient)
 }
 
+// ClientPacketBatchSender optional for a ClientPacketHandler. see PacketBatchSender
+type ClientPacketBatchSender interface {
+	PacketSendBatch(ctx context.Context, packets []*Packet, cli *TCPClient)
+}
+
+// sendPackets write the packets by the batch sender of the handler if implemented, or PacketSend per packet.
+func sendPackets(ctx context.Context, handler PacketHandler, packets []*Packet, session *Session) {
+	if batch, ok := handler.(PacketBatchSender); ok {
+		batch.PacketSendBatch(ctx, packets, session)
+		return
+	}
+	for _, pac := range packets {
+		handler.PacketSend(ctx, pac, session)
+	}
+}
+
+// clientSendPackets see sendPackets
+func clientSendPackets(ctx context.Context, handler ClientPacketHandler, packets []*Packet, cli *TCPClient) {
+	if batch, ok := handler.(ClientPacketBatchSender); ok {
+		batch.PacketSendBatch(ctx, packets, cli)
+		return
+	}
+	for _, pac := range packets {
+		handler.PacketSend(ctx, pac, cli)
+	}
+}
+
 // ConnectHandler on connect accept processor
 type ConnectHandler interface {
 	OnConnect(ctx context.Context, conn *net.TCPConn, tcpSer *TCPServer)
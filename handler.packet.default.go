@@ -5,9 +5,7 @@
 package gosocket
 
 import (
-	"bytes"
 	"context"
-	"encoding/binary"
 	"fmt"
 	"time"
 )
@@ -19,47 +17,58 @@ func (d defaultPacketHandler) PacketReceived(ctx context.Context, pac *Packet, s
 
 	// process chain if need extends
 
-	m, err := s.serRef.codec.Decode(ctx, pac.body, s)
+	var m interface{}
+	var err error
+	if !s.serRef.safeCall(s, "Codec.Decode", func() { m, err = s.serRef.codec.Decode(ctx, pac.body, s) }) {
+		return
+	}
 
 	if err != nil {
-		s.CloseSession(fmt.Sprint("Packet decode error. ", err))
+		s.CloseSessionWithCode(CloseProtocolError, fmt.Sprint("Packet decode error. ", err))
 		return
 	}
 
 	s.serRef.debugLogger.Printf("Packet received: sID: %s, len: %d, checksum: %d", s.sID, pac.len, pac.checksum)
 
 	s.UpdateLastActive()
-	s.serRef.messageListener.OnMessage(ctx, m, s)
-}
+	s.updateLastMessage()
 
-func (d defaultPacketHandler) PacketSend(_ context.Context, pac *Packet, s *Session) {
+	onMessage := func() {
+		s.serRef.safeCall(s, "MessageListener.OnMessage", func() { s.serRef.messageListener.OnMessage(ctx, m, s) })
+	}
 
-	if err := s.conn.SetWriteDeadline(time.Now().Add(s.writeDeadline)); err != nil {
-		s.CloseSession(fmt.Sprint("Set writeDeadline error.", err))
+	if dispatcher := s.serRef.dispatcher; dispatcher != nil {
+		// The listener run later on the worker, keep the pooled body until it returned
+		pac.Retain()
+		if !dispatcher.dispatch(ctx, s.sID, func() { defer pac.Release(); onMessage() }, pac.Release) {
+			pac.Release()
+			s.serRef.debugLogger.Printf("Worker pool saturated, message shed. sID: %s, len: %d", s.sID, pac.len)
+		}
 		return
 	}
 
-	// process chain if need extends
+	onMessage()
+}
 
-	dataBuf := new(bytes.Buffer)
-	var errs [4]error
+func (d defaultPacketHandler) PacketSend(ctx context.Context, pac *Packet, s *Session) {
+	d.PacketSendBatch(ctx, []*Packet{pac}, s)
+}
 
-	errs[0] = binary.Write(dataBuf, binary.BigEndian, pac.ver)      // Ver 8 bit
-	errs[1] = binary.Write(dataBuf, binary.BigEndian, pac.len)      // Size 32bit
-	errs[2] = binary.Write(dataBuf, binary.BigEndian, pac.body)     // Data body len
-	errs[3] = binary.Write(dataBuf, binary.BigEndian, pac.checksum) // Checksum 32bit
+func (d defaultPacketHandler) PacketSendBatch(_ context.Context, pacs []*Packet, s *Session) {
 
-	for _, err := range errs {
-		if err != nil {
-			s.CloseSession(fmt.Sprintf("Packet to binary error. packetLen: %d. %v", pac.len, err))
-			return
-		}
+	if err := s.conn.SetWriteDeadline(time.Now().Add(s.writeDeadline)); err != nil {
+		s.CloseSessionWithCode(CloseAbnormal, fmt.Sprint("Set writeDeadline error.", err))
+		return
 	}
 
-	s.serRef.debugLogger.Printf("Packet send: sID: %s, len: %d, checksum: %d", s.sID, pac.len, pac.checksum)
+	// process chain if need extends
+
+	for _, pac := range pacs {
+		s.serRef.debugLogger.Printf("Packet send: sID: %s, len: %d, checksum: %d", s.sID, pac.len, pac.checksum)
+	}
 
-	if i, err := s.conn.Write(dataBuf.Bytes()); err != nil {
-		s.CloseSession(fmt.Sprintf("Packet write to socket error. writeLen: %d. %v", i, err))
+	if i, err := writePackets(s.conn, pacs); err != nil {
+		s.CloseSessionWithCode(CloseAbnormal, fmt.Sprintf("Packet write to socket error. writeLen: %d. %v", i, err))
 		return
 	}
 	s.UpdateLastActive()
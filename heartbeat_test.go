@@ -0,0 +1,64 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+type testHeartbeatListener struct {
+	events chan HeartbeatEvent
+}
+
+func (t testHeartbeatListener) OnHeartbeat(_ *Session, event HeartbeatEvent) {
+	t.events <- event
+}
+
+func TestHeartbeatMissedPongs(t *testing.T) {
+	sessions := testCloseFrameSessionListener{created: make(chan *Session, 1), closed: make(chan CloseFrame, 1)}
+	events := make(chan HeartbeatEvent, 8)
+
+	server, err := NewTCPServer("127.0.0.1:18845").
+		RegisterMessageListener(&TestExampleServerMessageListener{}).
+		RegisterSessionListener(sessions).
+		RegisterHeartbeatListener(testHeartbeatListener{events: events}).
+		SetHeartbeat(50 * time.Millisecond).
+		SetMaxMissedPongs(2).
+		SetDebugMode(false).
+		Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	// A peer never answer pong
+	conn, err := net.Dial("tcp", "127.0.0.1:18845")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if s := <-sessions.created; s.Heartbeat() != 50*time.Millisecond {
+		t.Fatalf("expect heartbeat 50ms, got %v", s.Heartbeat())
+	}
+
+	select {
+	case frame := <-sessions.closed:
+		if frame.Code != CloseHeartbeatTimeout || frame.Reason != ReasonHeartbeatTimeout {
+			t.Fatalf("expect heartbeat timeout, got %+v", frame)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("expect session closed on heartbeat timeout")
+	}
+
+	want := []HeartbeatEvent{{Type: HeartbeatPingSent, Missed: 1}, {Type: HeartbeatPingSent, Missed: 2}, {Type: HeartbeatTimeout, Missed: 2}}
+	for _, w := range want {
+		if e := <-events; e != w {
+			t.Fatalf("expect event %+v, got %+v", w, e)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// Size classes of the pooled packet bodies, power of 2 from 64B to 1MB. Larger bodies are not pooled.
+const (
+	bufferMinClassBits = 6
+	bufferMaxClassBits = 20
+)
+
+var bufferPools [bufferMaxClassBits - bufferMinClassBits + 1]sync.Pool
+
+var packetPool = sync.Pool{New: func() interface{} { return new(Packet) }}
+
+// bufferClass return the size class fits size, -1 if too large to pool
+func bufferClass(size int) int {
+	if size > 1<<bufferMaxClassBits {
+		return -1
+	}
+
+	class := 0
+	for 1<<(bufferMinClassBits+class) < size {
+		class++
+	}
+	return class
+}
+
+// getBuffer return a buffer of len size from the pool of its size class.
+// - The holder is kept to put back, so the pool not allocate a slice header per put.
+func getBuffer(size int) *[]byte {
+	class := bufferClass(size)
+	if class < 0 {
+		buf := make([]byte, size)
+		return &buf
+	}
+
+	if holder, ok := bufferPools[class].Get().(*[]byte); ok {
+		*holder = (*holder)[:size]
+		return holder
+	}
+	buf := make([]byte, size, 1<<(bufferMinClassBits+class))
+	return &buf
+}
+
+// putBuffer put the buffer back to the pool of its size class. Not pooled size is dropped.
+func putBuffer(holder *[]byte) {
+	class := bufferClass(cap(*holder))
+	if class < 0 || cap(*holder) != 1<<(bufferMinClassBits+class) {
+		return
+	}
+	bufferPools[class].Put(holder)
+}
+
+// newPooledPacket get a packet with a body of len size from the pools, referenced once by the read loop.
+func newPooledPacket(ver byte, size uint32) *Packet {
+	pac := packetPool.Get().(*Packet)
+	pac.buf = getBuffer(int(size))
+	pac.ver = ver
+	pac.len = size
+	pac.body = *pac.buf
+	pac.refs = 1
+	return pac
+}
+
+// Retain keep the pooled body valid after the callback returned, until Release. No effect if not pooled.
+// - See TCPServer.SetBufferPooling
+func (p *Packet) Retain() {
+	if p.buf != nil {
+		atomic.AddInt32(&p.refs, 1)
+	}
+}
+
+// Release drop a reference of the pooled body. The packet and body go back to the pool on the last.
+// - The packet and body must not be used after. No effect if not pooled.
+func (p *Packet) Release() {
+	if p.buf == nil {
+		return
+	}
+
+	refs := atomic.AddInt32(&p.refs, -1)
+	if refs < 0 {
+		panic("gosocket: Packet released more than retained")
+	}
+	if refs == 0 {
+		putBuffer(p.buf)
+		*p = Packet{}
+		packetPool.Put(p)
+	}
+}
+
+type packetContextKey struct{}
+
+// PacketFromContext return the packet being received, to Retain its pooled body in Codec.Decode or OnMessage.
+// - Only set with the buffer pooling enabled, see TCPServer.SetBufferPooling
+func PacketFromContext(ctx context.Context) (*Packet, bool) {
+	pac, ok := ctx.Value(packetContextKey{}).(*Packet)
+	return pac, ok
+}
@@ -0,0 +1,190 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/adler32"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBufferPoolClass(t *testing.T) {
+	for size, expectCap := range map[int]int{0: 64, 1: 64, 64: 64, 65: 128, 4096: 4096, 5000: 8192, 1 << 20: 1 << 20} {
+		holder := getBuffer(size)
+		if len(*holder) != size || cap(*holder) != expectCap {
+			t.Errorf("size %d expect len %d cap %d, got len %d cap %d", size, size, expectCap, len(*holder), cap(*holder))
+		}
+		putBuffer(holder)
+	}
+
+	if holder := getBuffer(1<<20 + 1); cap(*holder) != 1<<20+1 {
+		t.Errorf("expect not pooled exact cap, got %d", cap(*holder))
+	}
+
+	// Not pooled packet ignore Retain, Release
+	pac := NewPacket(PacketVersion, 2, []byte("hi"), 0)
+	pac.Retain()
+	pac.Release()
+	pac.Release()
+	if string(pac.Body()) != "hi" {
+		t.Fatal("not pooled packet body changed")
+	}
+}
+
+// testRetainListener retain the pooled body, and check it is not reused before released
+type testRetainListener struct {
+	received chan string
+}
+
+func (l testRetainListener) OnMessage(ctx context.Context, message interface{}, _ *Session) {
+	pac, ok := PacketFromContext(ctx)
+	if !ok {
+		l.received <- "no packet in context"
+		return
+	}
+
+	pac.Retain()
+	go func() {
+		defer pac.Release()
+		time.Sleep(10 * time.Millisecond) // Later packets are read meanwhile
+		if string(pac.Body()) != message.(string) {
+			l.received <- fmt.Sprintf("retained body changed: %q, expect %q", pac.Body(), message)
+			return
+		}
+		l.received <- message.(string)
+	}()
+}
+
+func TestBufferPooling(t *testing.T) {
+	received := make(chan string, 16)
+
+	server, err := NewTCPServer("127.0.0.1:18848").
+		RegisterMessageListener(testRetainListener{received: received}).
+		SetWorkerPool(2, 16, DispatchWait).
+		SetBufferPooling(true).
+		SetDebugMode(false).
+		Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	client := NewTcpClient("127.0.0.1:18848").
+		RegisterMessageListener(&TestExampleClientListener{}).
+		SetDebugMode(false)
+	if _, err := client.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Hangup("Test done.")
+
+	expect := make(map[string]bool)
+	for i := 0; i < 8; i++ {
+		msg := fmt.Sprintf("message-%d", i)
+		expect[msg] = true
+		if err := client.SendMessage(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for range expect {
+		select {
+		case msg := <-received:
+			if !expect[msg] {
+				t.Fatal(msg)
+			}
+			delete(expect, msg)
+		case <-time.After(3 * time.Second):
+			t.Fatalf("timeout, not received %v", expect)
+		}
+	}
+}
+
+// benchDiscardCodec decode nothing, the read path is measured without the message copy
+type benchDiscardCodec struct{}
+
+func (benchDiscardCodec) Encode(_ context.Context, _ interface{}, _ *Session) ([]byte, error) {
+	return nil, nil
+}
+
+func (benchDiscardCodec) Decode(_ context.Context, _ []byte, _ *Session) (interface{}, error) {
+	return nil, nil
+}
+
+// benchReadListener signal done after n messages received
+type benchReadListener struct {
+	count *int64
+	n     int64
+	done  chan struct{}
+}
+
+func (l benchReadListener) OnMessage(_ context.Context, _ interface{}, _ *Session) {
+	if atomic.AddInt64(l.count, 1) == l.n {
+		close(l.done)
+	}
+}
+
+// Inbound packets of a high-rate session through the session read loop end-to-end, from a loopback TCP conn,
+// with the buffer pooling off and on. One op is one packet read, decoded and delivered to the listener.
+func BenchmarkPacketRead(b *testing.B) {
+	for _, size := range []int{128, 4096, 65536} {
+		body := make([]byte, size)
+		var data bytes.Buffer
+		if _, err := writePackets(&data, []*Packet{NewPacket(PacketVersion, uint32(size), body, adler32.Checksum(body))}); err != nil {
+			b.Fatal(err)
+		}
+
+		for _, pooling := range []bool{false, true} {
+			b.Run(fmt.Sprintf("pooling=%v/size=%d", pooling, size), func(b *testing.B) {
+				var count int64
+				done := make(chan struct{})
+				server := NewTCPServer("127.0.0.1:0").
+					RegisterMessageListener(benchReadListener{count: &count, n: int64(b.N), done: done}).
+					SetCodec(benchDiscardCodec{}).
+					SetBufferPooling(pooling).
+					SetDebugMode(false)
+
+				ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer ln.Close()
+				peer, err := net.DialTCP("tcp", nil, ln.Addr().(*net.TCPAddr))
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer peer.Close()
+				conn, err := ln.AcceptTCP()
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer conn.Close()
+
+				s := NewSession(conn, time.Second, time.Second, 0, server)
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+
+				go func() {
+					for i := 0; i < b.N; i++ {
+						if _, err := peer.Write(data.Bytes()); err != nil {
+							return
+						}
+					}
+				}()
+
+				b.SetBytes(int64(data.Len()))
+				b.ReportAllocs()
+				b.ResetTimer()
+
+				go defaultConnectHandler{}.readGo(ctx, s, server)
+				<-done
+			})
+		}
+	}
+}
@@ -11,6 +11,7 @@ import "hash/adler32"
 
 // Build heartbeat packet. cmd -> 0: ping; 1: pong
 // - see const HeartbeatCmdPing, HeartbeatCmdPong
+// - Without payload, no RTT measured. The server and client send ping with [seq][time] payload, see RTTStats
 func NewHeartbeatPacket(cmd byte) *Packet {
 	// Heartbeat can represent 256 instructions. 0: ping; 1: pong
 	cmdBody := make([]byte, 1)
@@ -19,3 +20,13 @@ func NewHeartbeatPacket(cmd byte) *Packet {
 
 	return NewPacket(PacketHeartbeatVersion, 1, cmdBody, checksum)
 }
+
+// Build control packet. Sent as heartbeat version packet, not through Codec.
+// - see const ControlCmdSubscribe, ControlCmdUnsubscribe
+func NewControlPacket(cmd byte, payload []byte) *Packet {
+	body := make([]byte, 1+len(payload))
+	body[0] = cmd
+	copy(body[1:], payload)
+
+	return NewPacket(PacketHeartbeatVersion, uint32(len(body)), body, adler32.Checksum(body))
+}
@@ -0,0 +1,142 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+const (
+	packetHeaderLen   = 5 // Ver 8bit + Size 32bit
+	packetChecksumLen = 4 // Checksum 32bit
+)
+
+// readPacket read one whole packet from r. Blocks until the packet is complete or r fails.
+// - Used where no read loop is running yet, e.g. the connection handshake.
+func readPacket(r io.Reader, maxBodyLen uint32) (*Packet, error) {
+	var header [packetHeaderLen]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	ver := header[0]
+	if ver != PacketVersion && ver != PacketHeartbeatVersion {
+		return nil, fmt.Errorf("Ver(%d) is wrong. ", ver)
+	}
+
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > maxBodyLen {
+		return nil, fmt.Errorf("Recv packet size(%d) exceed max limit. ", size)
+	}
+
+	buf := make([]byte, size+packetChecksumLen) // body + checksum
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+
+	pac := NewPacket(ver, size, buf[:size], binary.BigEndian.Uint32(buf[size:]))
+	if !pac.Checksum() {
+		return nil, fmt.Errorf("Checksum error. Check false. ")
+	}
+
+	return pac, nil
+}
+
+// writePacket write one whole packet to w with a single Write call.
+func writePacket(w io.Writer, pac *Packet) error {
+	buf := make([]byte, packetHeaderLen+len(pac.body)+packetChecksumLen)
+
+	buf[0] = pac.ver
+	binary.BigEndian.PutUint32(buf[1:], pac.len)
+	copy(buf[packetHeaderLen:], pac.body)
+	binary.BigEndian.PutUint32(buf[packetHeaderLen+len(pac.body):], pac.checksum)
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// packetBatch the headers and the vector of a batch write, pooled
+type packetBatch struct {
+	head []byte      // [ver][size][checksum] of each packet
+	bufs net.Buffers // header, body, checksum of each packet
+}
+
+var packetBatchPool = sync.Pool{New: func() interface{} { return new(packetBatch) }}
+
+// writePackets write the packets to w with one vectored write (writev on a TCP conn).
+// - The bodies are not copied, the headers and checksums are built in a pooled buffer.
+func writePackets(w io.Writer, pacs []*Packet) (int64, error) {
+	const frameLen = packetHeaderLen + packetChecksumLen
+
+	b := packetBatchPool.Get().(*packetBatch)
+	if cap(b.head) < len(pacs)*frameLen {
+		b.head = make([]byte, len(pacs)*frameLen)
+	}
+	head := b.head[:len(pacs)*frameLen]
+
+	bufs := b.bufs[:0]
+	for i, pac := range pacs {
+		frame := head[i*frameLen : (i+1)*frameLen]
+		frame[0] = pac.ver
+		binary.BigEndian.PutUint32(frame[1:], pac.len)
+		binary.BigEndian.PutUint32(frame[packetHeaderLen:], pac.checksum)
+
+		bufs = append(bufs, frame[:packetHeaderLen], pac.body, frame[packetHeaderLen:])
+	}
+	b.bufs = bufs
+
+	n, err := bufs.WriteTo(w) // Consume the local copy, b.bufs keep the backing array
+
+	for i := range b.bufs { // Not hold the bodies in the pool
+		b.bufs[i] = nil
+	}
+	packetBatchPool.Put(b)
+
+	return n, err
+}
+
+// decodePacket decode the packet at the head of data without blocking, for the event loop.
+// - Return nil if data is not a whole packet yet, and the bytes consumed.
+// - The body is copied (into a pooled buffer if pooled), data can be reused.
+// - The error is a CloseFrame, the code to close the session with.
+func decodePacket(data []byte, maxBodyLen uint32, pooled bool) (*Packet, int, error) {
+	if len(data) < packetHeaderLen {
+		return nil, 0, nil
+	}
+
+	ver := data[0]
+	if ver != PacketVersion && ver != PacketHeartbeatVersion {
+		return nil, 0, CloseFrame{Code: CloseProtocolError, Reason: fmt.Sprintf("Ver(%d) is wrong.", ver)}
+	}
+
+	size := binary.BigEndian.Uint32(data[1:])
+	if size > maxBodyLen {
+		return nil, 0, CloseFrame{Code: CloseMessageTooBig, Reason: fmt.Sprintf("Recv packet size(%d) exceed max limit. ", size)}
+	}
+
+	total := packetHeaderLen + int(size) + packetChecksumLen
+	if len(data) < total {
+		return nil, 0, nil
+	}
+
+	var pac *Packet
+	if pooled {
+		pac = newPooledPacket(ver, size)
+	} else {
+		pac = NewPacket(ver, size, make([]byte, size), 0)
+	}
+	copy(pac.body, data[packetHeaderLen:])
+	pac.checksum = binary.BigEndian.Uint32(data[packetHeaderLen+int(size):])
+
+	if !pac.Checksum() {
+		pac.Release()
+		return nil, 0, CloseFrame{Code: CloseProtocolError, Reason: "Checksum error. Check false. "}
+	}
+	return pac, total, nil
+}
@@ -0,0 +1,186 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/adler32"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWritePackets(t *testing.T) {
+	bodies := [][]byte{[]byte("hello"), {}, bytes.Repeat([]byte("x"), 1000)}
+
+	var pacs []*Packet
+	for _, body := range bodies {
+		pacs = append(pacs, NewPacket(PacketVersion, uint32(len(body)), body, adler32.Checksum(body)))
+	}
+	pacs = append(pacs, NewHeartbeatPacket(HeartbeatCmdPing))
+
+	var buf bytes.Buffer
+	n, err := writePackets(&buf, pacs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Fatalf("expect written %d, got %d", buf.Len(), n)
+	}
+
+	for i, expect := range pacs {
+		pac, err := readPacket(&buf, 1<<20)
+		if err != nil {
+			t.Fatalf("packet %d read error. %v", i, err)
+		}
+		if pac.ver != expect.ver || !bytes.Equal(pac.body, expect.body) {
+			t.Fatalf("packet %d expect ver %d body %q, got ver %d body %q", i, expect.ver, expect.body, pac.ver, pac.body)
+		}
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("expect all read, %d bytes left", buf.Len())
+	}
+}
+
+// benchConn a loopback TCP conn, the peer drain everything written.
+// testSinglePacketHandler a PacketHandler without PacketSendBatch
+type testSinglePacketHandler struct {
+	sent *[]*Packet
+}
+
+func (h testSinglePacketHandler) PacketReceived(_ context.Context, _ *Packet, _ *Session) {}
+
+func (h testSinglePacketHandler) PacketSend(_ context.Context, pac *Packet, _ *Session) {
+	*h.sent = append(*h.sent, pac)
+}
+
+func TestSendPacketsFallback(t *testing.T) {
+	var sent []*Packet
+	pacs := []*Packet{NewControlPacket(HeartbeatCmdPing, nil), NewControlPacket(HeartbeatCmdPong, nil)}
+
+	sendPackets(context.Background(), testSinglePacketHandler{sent: &sent}, pacs, nil)
+
+	if len(sent) != 2 || sent[0] != pacs[0] || sent[1] != pacs[1] {
+		t.Fatalf("expect PacketSend per packet in order, got %d", len(sent))
+	}
+}
+
+func benchConn(b *testing.B) *net.TCPConn {
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		peer, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		_, _ = io.Copy(io.Discard, peer)
+		_ = peer.Close()
+	}()
+
+	conn, err := net.DialTCP("tcp", nil, ln.Addr().(*net.TCPAddr))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+// Small messages written to a socket. One op is one packet.
+func BenchmarkSmallPacketWrite(b *testing.B) {
+	body := []byte(`{"type":"chat","text":"hi"}`)
+	pac := NewPacket(PacketVersion, uint32(len(body)), body, adler32.Checksum(body))
+
+	// The write before batching: bytes.Buffer with binary.Write, one conn.Write per packet
+	b.Run("perPacket", func(b *testing.B) {
+		conn := benchConn(b)
+		b.SetBytes(int64(packetHeaderLen + len(body) + packetChecksumLen))
+		b.ReportAllocs()
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			dataBuf := new(bytes.Buffer)
+			_ = binary.Write(dataBuf, binary.BigEndian, pac.ver)
+			_ = binary.Write(dataBuf, binary.BigEndian, pac.len)
+			_ = binary.Write(dataBuf, binary.BigEndian, pac.body)
+			_ = binary.Write(dataBuf, binary.BigEndian, pac.checksum)
+			if _, err := conn.Write(dataBuf.Bytes()); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	for _, size := range []int{1, defaultSendChanelCacheSize, maxWriteBatch} {
+		size := size
+		b.Run(fmt.Sprintf("batch=%d", size), func(b *testing.B) {
+			conn := benchConn(b)
+			pacs := make([]*Packet, size)
+			for i := range pacs {
+				pacs[i] = pac
+			}
+			b.SetBytes(int64(packetHeaderLen + len(body) + packetChecksumLen))
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i += size {
+				if _, err := writePackets(conn, pacs); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+type benchCountListener struct {
+	count *int64
+}
+
+func (l benchCountListener) OnMessage(_ context.Context, _ interface{}, _ *Session) {
+	atomic.AddInt64(l.count, 1)
+}
+
+// Client send small messages to the server as fast as possible, the write loop coalesce the queued.
+func BenchmarkClientSmallMessageThroughput(b *testing.B) {
+	var count int64
+	server, err := NewTCPServer("127.0.0.1:18847").
+		RegisterMessageListener(benchCountListener{count: &count}).
+		SetDebugMode(false).
+		Run()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer server.Stop()
+
+	client := NewTcpClient("127.0.0.1:18847").
+		RegisterMessageListener(&TestExampleClientListener{}).
+		SetDebugMode(false)
+	if _, err := client.Dial(); err != nil {
+		b.Fatal(err)
+	}
+	defer client.Hangup("Bench done.")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := client.SendMessage("hi"); err != nil {
+			b.Fatal(err)
+		}
+	}
+	for deadline := time.Now().Add(10 * time.Second); atomic.LoadInt64(&count) < int64(b.N); {
+		if time.Now().After(deadline) {
+			b.Fatalf("expect %d received, got %d", b.N, atomic.LoadInt64(&count))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
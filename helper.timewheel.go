@@ -0,0 +1,264 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"sync"
+	"time"
+)
+
+// timeWheel hierarchical timing wheel. One goroutine ticks the timers of all sessions.
+// - Level 0 slot is one tick, level n slot is slots^n ticks. A timer cascade down to the lower level when its slot comes.
+// - Schedule and stop are O(1) and allocate one timer, instead of a runtime timer per select loop.
+// - The callbacks run on the wheel goroutine, they must not block.
+type timeWheel struct {
+	tick    time.Duration
+	slots   uint64
+	spans   []uint64        // Ticks of one slot of each level
+	buckets [][]*wheelTimer // [level][slot] head of the timer list
+	now     uint64          // Ticks elapsed since begin
+	fired   []func()        // Callbacks of the current tick, reused
+	begin   time.Time
+	quit    chan struct{}
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+}
+
+// wheelTimer a timer scheduled in the wheel, linked in its bucket list.
+type wheelTimer struct {
+	expire uint64
+	period uint64        // Ticks. Re-add after fired if not 0
+	fn     func()        // Called when fired, if c is nil
+	c      chan struct{} // Signaled when fired, dropped if full
+	wheel  *timeWheel
+	level  int
+	slot   uint64
+	linked bool
+	prev   *wheelTimer
+	next   *wheelTimer
+}
+
+func newTimeWheel(tick time.Duration, slots int, levels int) *timeWheel {
+	tw := &timeWheel{
+		tick:    tick,
+		slots:   uint64(slots),
+		spans:   make([]uint64, levels),
+		buckets: make([][]*wheelTimer, levels),
+		begin:   time.Now(),
+		quit:    make(chan struct{}),
+	}
+
+	span := uint64(1)
+	for i := range tw.buckets {
+		tw.spans[i] = span
+		tw.buckets[i] = make([]*wheelTimer, slots)
+		span *= tw.slots
+	}
+	return tw
+}
+
+var (
+	sharedWheel     *timeWheel
+	sharedWheelOnce sync.Once
+)
+
+// sharedTimeWheel the wheel shared by all the clients, started on first use and never stopped.
+func sharedTimeWheel() *timeWheel {
+	sharedWheelOnce.Do(func() {
+		sharedWheel = newTimeWheel(timeWheelTick, timeWheelSlots, timeWheelLevels)
+		sharedWheel.start()
+	})
+	return sharedWheel
+}
+
+func (tw *timeWheel) start() {
+	tw.begin = time.Now()
+	tw.wg.Add(1)
+	go tw.run()
+}
+
+func (tw *timeWheel) stop() {
+	close(tw.quit)
+	tw.wg.Wait()
+}
+
+func (tw *timeWheel) run() {
+	defer tw.wg.Done()
+
+	ticker := time.NewTicker(tw.tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-tw.quit:
+			return
+		case now := <-ticker.C:
+			// Catch up the ticks missed if the goroutine was late
+			tw.advanceTo(uint64(now.Sub(tw.begin) / tw.tick))
+		}
+	}
+}
+
+// advanceTo move the wheel to the tick, fire the expired timers of each tick on the way.
+func (tw *timeWheel) advanceTo(target uint64) {
+	for {
+		tw.mu.Lock()
+		if tw.now >= target {
+			tw.mu.Unlock()
+			return
+		}
+		tw.now++
+
+		// Cascade the higher levels first, so the timers fall through to level 0 in the same tick
+		for level := len(tw.spans) - 1; level > 0; level-- {
+			if tw.now%tw.spans[level] == 0 {
+				tw.cascade(level, (tw.now/tw.spans[level])%tw.slots)
+			}
+		}
+
+		slot := tw.now % tw.slots
+		head := tw.buckets[0][slot]
+		tw.buckets[0][slot] = nil
+
+		fired := tw.fired[:0]
+		for t := head; t != nil; {
+			next := t.next
+			t.prev, t.next, t.linked = nil, nil, false
+			if t.period > 0 {
+				t.expire = tw.now + t.period
+				tw.add(t)
+			}
+			if t.c != nil {
+				select {
+				case t.c <- struct{}{}:
+				default:
+				}
+			} else {
+				fired = append(fired, t.fn)
+			}
+			t = next
+		}
+		tw.fired = fired
+		tw.mu.Unlock()
+
+		// Only the wheel goroutine advance, fired is not touched until the next tick
+		for _, fn := range fired {
+			fn()
+		}
+	}
+}
+
+// cascade re-add the timers of the slot, they go to the lower levels. Called with mu held.
+func (tw *timeWheel) cascade(level int, slot uint64) {
+	head := tw.buckets[level][slot]
+	tw.buckets[level][slot] = nil
+
+	for t := head; t != nil; {
+		next := t.next
+		t.linked = false
+		tw.add(t)
+		t = next
+	}
+}
+
+// add link the timer to the bucket of its expire. Called with mu held.
+func (tw *timeWheel) add(t *wheelTimer) {
+	if t.expire < tw.now { // Cascaded at its expire tick fire in this tick, delta 0 to the current slot
+		t.expire = tw.now
+	}
+
+	delta := t.expire - tw.now
+	expire := t.expire
+	level := 0
+	for level < len(tw.spans)-1 && delta >= tw.spans[level]*tw.slots {
+		level++
+	}
+	if top := tw.spans[level] * tw.slots; delta >= top { // Beyond the top level, re-cascade until in range
+		expire = tw.now + top - 1
+	}
+
+	t.level = level
+	t.slot = (expire / tw.spans[level]) % tw.slots
+	t.prev = nil
+	t.next = tw.buckets[level][t.slot]
+	if t.next != nil {
+		t.next.prev = t
+	}
+	tw.buckets[level][t.slot] = t
+	t.linked = true
+}
+
+// afterFunc call fn on the wheel goroutine after d, rounded up to the tick.
+func (tw *timeWheel) afterFunc(d time.Duration, fn func()) *wheelTimer {
+	t := &wheelTimer{fn: fn, wheel: tw}
+	t.reset(d)
+	return t
+}
+
+// ticks return d in ticks, rounded up, at least 1
+func (tw *timeWheel) ticks(d time.Duration) uint64 {
+	if ticks := uint64((d + tw.tick - 1) / tw.tick); ticks > 0 {
+		return ticks
+	}
+	return 1
+}
+
+// reset reschedule the timer to fire after d, whether it fired, stopped or pending.
+func (t *wheelTimer) reset(d time.Duration) {
+	tw := t.wheel
+	ticks := tw.ticks(d)
+
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	tw.unlink(t)
+	t.expire = tw.now + ticks
+	tw.add(t)
+}
+
+// stop unlink the timer. Return false if it already fired or stopped.
+func (t *wheelTimer) stop() bool {
+	tw := t.wheel
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	return tw.unlink(t)
+}
+
+// unlink remove the timer from its bucket if linked. Called with mu held.
+func (tw *timeWheel) unlink(t *wheelTimer) bool {
+	if !t.linked {
+		return false
+	}
+	if t.prev != nil {
+		t.prev.next = t.next
+	} else {
+		tw.buckets[t.level][t.slot] = t.next
+	}
+	if t.next != nil {
+		t.next.prev = t.prev
+	}
+	t.prev, t.next, t.linked = nil, nil, false
+	return true
+}
+
+// wheelTicker deliver a tick to C every period, dropped if the last one not received yet. As time.Ticker.
+// - Re-added by the wheel when fired, no goroutine nor allocation per tick.
+type wheelTicker struct {
+	C     <-chan struct{}
+	timer wheelTimer
+}
+
+func (tw *timeWheel) newTicker(period time.Duration) *wheelTicker {
+	c := make(chan struct{}, 1)
+	tk := &wheelTicker{C: c}
+	tk.timer = wheelTimer{period: tw.ticks(period), c: c, wheel: tw}
+	tk.timer.reset(period)
+	return tk
+}
+
+func (tk *wheelTicker) stop() {
+	tk.timer.stop()
+}
@@ -0,0 +1,152 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTimeWheel(t *testing.T) {
+	tw := newTimeWheel(time.Millisecond, 8, 3) // Not started, advanced by hand. Levels span 8, 64, 512 ticks
+
+	fired := make(map[string]uint64)
+	schedule := func(name string, ticks int) *wheelTimer {
+		return tw.afterFunc(time.Duration(ticks)*time.Millisecond, func() { fired[name] = tw.now })
+	}
+
+	schedule("level0", 5)
+	schedule("level1", 20)
+	schedule("level2", 300)
+	schedule("beyond", 2000)
+	stopped := schedule("stopped", 30)
+	if !stopped.stop() || stopped.stop() {
+		t.Fatal("expect stop true once")
+	}
+
+	tw.advanceTo(3000)
+
+	for name, expect := range map[string]uint64{"level0": 5, "level1": 20, "level2": 300, "beyond": 2000} {
+		if fired[name] != expect {
+			t.Errorf("%s expect fired at tick %d, got %d", name, expect, fired[name])
+		}
+	}
+	if _, ok := fired["stopped"]; ok {
+		t.Error("stopped timer fired")
+	}
+
+	// Ticker keep firing every period
+	tk := tw.newTicker(10 * time.Millisecond)
+	ticks := 0
+	for i := 0; i < 5; i++ {
+		tw.advanceTo(tw.now + 10)
+		select {
+		case <-tk.C:
+			ticks++
+		default:
+		}
+	}
+	tk.stop()
+	tw.advanceTo(tw.now + 10)
+	if ticks != 5 || len(tk.C) != 0 {
+		t.Fatalf("expect 5 ticks and none after stop, got %d, %d", ticks, len(tk.C))
+	}
+}
+
+// Each session loop iteration reschedule its heartbeat. time.After allocate a runtime timer per iteration,
+// the wheel reuse the session timer.
+func BenchmarkHeartbeatReschedule(b *testing.B) {
+	for _, sessions := range []int{1000, 10000, 100000} {
+		b.Run(fmt.Sprintf("time.After/sessions=%d", sessions), func(b *testing.B) {
+			timers := make([]*time.Timer, sessions)
+			for i := range timers {
+				timers[i] = time.NewTimer(sessionDefaultHeartbeat)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				k := i % sessions
+				timers[k].Stop()
+				timers[k] = time.NewTimer(sessionDefaultHeartbeat)
+			}
+
+			b.StopTimer()
+			for _, timer := range timers {
+				timer.Stop()
+			}
+		})
+
+		b.Run(fmt.Sprintf("timeWheel/sessions=%d", sessions), func(b *testing.B) {
+			tw := newTimeWheel(timeWheelTick, timeWheelSlots, timeWheelLevels)
+			timers := make([]*wheelTimer, sessions)
+			for i := range timers {
+				timers[i] = tw.afterFunc(sessionDefaultHeartbeat, func() {})
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				timers[i%sessions].reset(sessionDefaultHeartbeat)
+			}
+		})
+	}
+}
+
+// One heartbeat period of the sessions: every session ticker fire once, all from one goroutine.
+func BenchmarkHeartbeatPeriod(b *testing.B) {
+	for _, sessions := range []int{1000, 10000, 100000} {
+		b.Run(fmt.Sprintf("timeWheel/sessions=%d", sessions), func(b *testing.B) {
+			tw := newTimeWheel(timeWheelTick, timeWheelSlots, timeWheelLevels)
+			tickers := make([]*wheelTicker, sessions)
+			for i := range tickers {
+				tickers[i] = tw.newTicker(sessionDefaultHeartbeat)
+			}
+			period := uint64(sessionDefaultHeartbeat / timeWheelTick)
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				tw.advanceTo(tw.now + period)
+				for _, tk := range tickers {
+					<-tk.C
+				}
+			}
+		})
+	}
+}
+
+// Memory of the heartbeat tickers held by the idle sessions
+func BenchmarkHeartbeatSessions(b *testing.B) {
+	const sessions = 100000
+
+	b.Run("time.Ticker", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			tickers := make([]*time.Ticker, sessions)
+			for k := range tickers {
+				tickers[k] = time.NewTicker(sessionDefaultHeartbeat)
+			}
+			for _, tk := range tickers {
+				tk.Stop()
+			}
+		}
+	})
+
+	b.Run("timeWheel", func(b *testing.B) {
+		tw := newTimeWheel(timeWheelTick, timeWheelSlots, timeWheelLevels)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			tickers := make([]*wheelTicker, sessions)
+			for k := range tickers {
+				tickers[k] = tw.newTicker(sessionDefaultHeartbeat)
+			}
+			for _, tk := range tickers {
+				tk.stop()
+			}
+		}
+	})
+}
@@ -0,0 +1,114 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// LimitPolicy what happens when the server reach the max sessions limit
+type LimitPolicy int
+
+const (
+	// LimitPolicyReject close the new connection at once
+	LimitPolicyReject LimitPolicy = iota
+	// LimitPolicyQueue stop accepting until a session closed, new connections wait in the listen backlog.
+	// - Only for the global max sessions limit. Over the per IP limit always rejected,
+	//   queueing them would let one address hold the accept loop.
+	LimitPolicyQueue
+)
+
+// RejectReason why a connection is rejected before session create
+type RejectReason string
+
+const (
+	RejectMaxSessions      RejectReason = "Max sessions limit"
+	RejectMaxSessionsPerIP RejectReason = "Max sessions per IP limit"
+	RejectHandshake        RejectReason = "Handshake failure"
+	RejectIPDenied         RejectReason = "IP denied"
+)
+
+// connLimiter count the accepted connections, total and per remote IP.
+type connLimiter struct {
+	maxTotal int // 0: unlimited
+	maxPerIP int // 0: unlimited
+	total    int
+	perIP    map[string]int
+	slotFree chan struct{}
+	mu       sync.Mutex
+}
+
+func newConnLimiter(maxTotal int, maxPerIP int) *connLimiter {
+	return &connLimiter{
+		maxTotal: maxTotal,
+		maxPerIP: maxPerIP,
+		perIP:    make(map[string]int),
+		slotFree: make(chan struct{}, 1),
+	}
+}
+
+// acquire take a slot for the ip. Return false and the reason if over limit.
+func (l *connLimiter) acquire(ip string) (RejectReason, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.maxTotal > 0 && l.total >= l.maxTotal {
+		return RejectMaxSessions, false
+	}
+	if l.maxPerIP > 0 && l.perIP[ip] >= l.maxPerIP {
+		return RejectMaxSessionsPerIP, false
+	}
+
+	l.total++
+	l.perIP[ip]++
+	return "", true
+}
+
+// release give back the slot of the ip.
+func (l *connLimiter) release(ip string) {
+	l.mu.Lock()
+
+	l.total--
+	if l.perIP[ip]--; l.perIP[ip] <= 0 {
+		delete(l.perIP, ip)
+	}
+
+	l.mu.Unlock()
+
+	select {
+	case l.slotFree <- struct{}{}:
+	default:
+	}
+}
+
+// waitSlot block until total under the max limit. Return false if ctx done.
+func (l *connLimiter) waitSlot(ctx context.Context) bool {
+	for {
+		l.mu.Lock()
+		full := l.maxTotal > 0 && l.total >= l.maxTotal
+		l.mu.Unlock()
+
+		if !full {
+			return true
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-l.slotFree:
+		}
+	}
+}
+
+// remoteIP return the ip string of the conn remote address
+func remoteIP(conn net.Conn) string {
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.IP.String()
+	}
+	host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	return host
+}
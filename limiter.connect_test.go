@@ -0,0 +1,119 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestConnLimiter(t *testing.T) {
+	l := newConnLimiter(3, 2)
+
+	for i := 0; i < 2; i++ {
+		if _, ok := l.acquire("10.0.0.1"); !ok {
+			t.Fatal("expect acquire ok under limit")
+		}
+	}
+	if reason, ok := l.acquire("10.0.0.1"); ok || reason != RejectMaxSessionsPerIP {
+		t.Fatalf("expect per IP reject, got %v %s", ok, reason)
+	}
+	if _, ok := l.acquire("10.0.0.2"); !ok {
+		t.Fatal("expect other ip acquire ok")
+	}
+	if reason, ok := l.acquire("10.0.0.3"); ok || reason != RejectMaxSessions {
+		t.Fatalf("expect max sessions reject, got %v %s", ok, reason)
+	}
+
+	// Queue: wait until a slot released.
+	go func() {
+		<-time.After(50 * time.Millisecond)
+		l.release("10.0.0.1")
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if !l.waitSlot(ctx) {
+		t.Fatal("expect slot free after release")
+	}
+	if _, ok := l.acquire("10.0.0.3"); !ok {
+		t.Fatal("expect acquire ok after release")
+	}
+}
+
+type testRejectListener struct {
+	rejected chan RejectReason
+}
+
+func (l testRejectListener) OnConnectionRejected(_ string, reason RejectReason) {
+	l.rejected <- reason
+}
+
+func TestServerConnLimit(t *testing.T) {
+	rejected := make(chan RejectReason, 4)
+
+	server, err := NewTCPServer("127.0.0.1:18851").
+		RegisterMessageListener(&TestExampleServerMessageListener{}).
+		RegisterConnectionRejectListener(testRejectListener{rejected: rejected}).
+		SetMaxSessions(3).
+		SetMaxSessionsPerIP(1).
+		SetDebugMode(false).
+		Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	dial := func(localIP string) (*TCPClient, chan CloseFrame) {
+		closed := make(chan CloseFrame, 1)
+		client, err := NewTcpClient("127.0.0.1:18851").
+			RegisterMessageListener(&TestExampleClientListener{}).
+			RegisterConnectionListener(testClientCloseListener{closed: closed}).
+			SetLocalAddr(localIP + ":0").
+			SetDebugMode(false).
+			Dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return client, closed
+	}
+	expectRejected := func(closed chan CloseFrame, reason RejectReason) {
+		select {
+		case frame := <-closed:
+			if frame.Code != CloseTryAgainLater || frame.Reason != string(reason) {
+				t.Fatalf("expect close %d(%s), got %d(%s)", CloseTryAgainLater, reason, frame.Code, frame.Reason)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("expect close %s, timeout", reason)
+		}
+		select {
+		case got := <-rejected:
+			if got != reason {
+				t.Fatalf("expect reject listener %s, got %s", reason, got)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("expect reject listener %s, timeout", reason)
+		}
+	}
+
+	// Distinct loopback source IPs, to reach max sessions before max per IP
+	first, _ := dial("127.0.0.1")
+	defer first.Hangup("Test done.")
+	second, _ := dial("127.0.0.2")
+	defer second.Hangup("Test done.")
+
+	_, closed := dial("127.0.0.1")
+	expectRejected(closed, RejectMaxSessionsPerIP)
+
+	third, _ := dial("127.0.0.3")
+	defer third.Hangup("Test done.")
+
+	_, closed = dial("127.0.0.4")
+	expectRejected(closed, RejectMaxSessions)
+
+	if n := len(server.Sessions()); n != 3 {
+		t.Fatalf("expect 3 sessions, got %d", n)
+	}
+}
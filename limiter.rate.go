@@ -0,0 +1,112 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimitPolicy what happens when a session exceeds its inbound rate limit
+type RateLimitPolicy int
+
+const (
+	// RateLimitDrop drop the message, not delivered to MessageListener
+	RateLimitDrop RateLimitPolicy = iota
+	// RateLimitDelay stop reading the session until the tokens refilled (backpressure to the peer)
+	RateLimitDelay
+	// RateLimitClose close the session
+	RateLimitClose
+)
+
+// RateLimit session inbound token-bucket limits. Zero value means unlimited.
+type RateLimit struct {
+	MessagesPerSecond float64         // Messages refill rate. 0: unlimited
+	MessagesBurst     float64         // Messages bucket size. 0: same as MessagesPerSecond (at least 1)
+	BytesPerSecond    float64         // Packet body bytes refill rate. 0: unlimited
+	BytesBurst        float64         // Bytes bucket size. 0: same as BytesPerSecond
+	Policy            RateLimitPolicy // On exceeded
+}
+
+// Unlimited return no limit is configured
+func (r RateLimit) Unlimited() bool {
+	return r.MessagesPerSecond <= 0 && r.BytesPerSecond <= 0
+}
+
+// tokenBucket classic token bucket. Not goroutine safe, guarded by rateLimiter.
+type tokenBucket struct {
+	rate   float64 // tokens per second, 0: unlimited
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst float64, now time.Time) tokenBucket {
+	if burst <= 0 {
+		burst = math.Max(rate, 1)
+	}
+	return tokenBucket{rate: rate, burst: burst, tokens: burst, last: now}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// available return n tokens can be taken now. n larger than burst need a full bucket.
+func (b *tokenBucket) available(n float64) bool {
+	return b.rate <= 0 || b.tokens >= math.Min(n, b.burst)
+}
+
+// take take n tokens, may go into debt. Return the time until the debt paid.
+func (b *tokenBucket) take(n float64) time.Duration {
+	if b.rate <= 0 {
+		return 0
+	}
+	if b.tokens -= n; b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// rateLimiter messages and bytes buckets of one session
+type rateLimiter struct {
+	limit    RateLimit
+	messages tokenBucket
+	bytes    tokenBucket
+	mu       sync.Mutex
+}
+
+func newRateLimiter(limit RateLimit) *rateLimiter {
+	now := time.Now()
+	return &rateLimiter{
+		limit:    limit,
+		messages: newTokenBucket(limit.MessagesPerSecond, limit.MessagesBurst, now),
+		bytes:    newTokenBucket(limit.BytesPerSecond, limit.BytesBurst, now),
+	}
+}
+
+// take account one message of size bytes.
+// - Drop/Close policy: return false if exceeded, nothing taken.
+// - Delay policy: always taken, return the time to wait before continue reading.
+func (l *rateLimiter) take(size int) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.messages.refill(now)
+	l.bytes.refill(now)
+
+	if l.limit.Policy != RateLimitDelay && (!l.messages.available(1) || !l.bytes.available(float64(size))) {
+		return 0, false
+	}
+
+	msgWait := l.messages.take(1)
+	if bytesWait := l.bytes.take(float64(size)); bytesWait > msgWait {
+		return bytesWait, true
+	}
+	return msgWait, true
+}
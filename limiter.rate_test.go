@@ -0,0 +1,46 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"testing"
+)
+
+func TestRateLimiterDrop(t *testing.T) {
+	l := newRateLimiter(RateLimit{MessagesPerSecond: 10, MessagesBurst: 3, Policy: RateLimitDrop})
+
+	for i := 0; i < 3; i++ {
+		if _, ok := l.take(1); !ok {
+			t.Fatalf("expect message %d in burst allowed", i)
+		}
+	}
+	if _, ok := l.take(1); ok {
+		t.Fatal("expect message over burst dropped")
+	}
+}
+
+func TestRateLimiterBytes(t *testing.T) {
+	l := newRateLimiter(RateLimit{BytesPerSecond: 1024, Policy: RateLimitClose})
+
+	// Larger than burst allowed with a full bucket, then the bucket in debt.
+	if _, ok := l.take(4096); !ok {
+		t.Fatal("expect large message allowed on full bucket")
+	}
+	if _, ok := l.take(1); ok {
+		t.Fatal("expect exceeded after large message")
+	}
+}
+
+func TestRateLimiterDelay(t *testing.T) {
+	l := newRateLimiter(RateLimit{MessagesPerSecond: 100, MessagesBurst: 1, Policy: RateLimitDelay})
+
+	if wait, ok := l.take(1); !ok || wait != 0 {
+		t.Fatalf("expect first message no wait, got %v", wait)
+	}
+	wait, ok := l.take(1)
+	if !ok || wait <= 0 {
+		t.Fatalf("expect delay policy wait, got %v %v", wait, ok)
+	}
+}
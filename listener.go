@@ -4,7 +4,10 @@
 
 package gosocket
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 // MessageListener message processor interface
 // Usage:
@@ -16,7 +19,50 @@ type ClientMessageListener interface {
 	OnMessage(ctx context.Context, message interface{}, cli *TCPClient)
 }
 
+// ClientConnectionListener listening the client connection lifecycle.
+// - OnConnected: Dial or reconnect succeeded.
+// - OnDisconnected: closed by Hangup, by the server or connection error. reason.Code is CloseAbnormal
+//   if the connection dropped without a close packet. err is the local error, nil if closed by Hangup or the server.
+// - OnReconnecting: before each auto reconnect attempt, start from 1. see TCPClient.SetAutoReconnect
+type ClientConnectionListener interface {
+	OnConnected(cli *TCPClient)
+	OnDisconnected(cli *TCPClient, reason CloseFrame, err error)
+	OnReconnecting(cli *TCPClient, attempt int)
+}
+
+// HeartbeatEventType the kind of heartbeat event
+type HeartbeatEventType int
+
+const (
+	HeartbeatPingSent     HeartbeatEventType = iota // Missed: the pings without pong, include this one
+	HeartbeatPongReceived                           // RTT: the sample, zero if the peer not echo the ping time
+	HeartbeatTimeout                                // Missed: exceed the max missed pongs, the connection closing
+)
+
+// HeartbeatEvent the heartbeat ping/pong event
+type HeartbeatEvent struct {
+	Type   HeartbeatEventType
+	Missed int
+	RTT    time.Duration
+}
+
+// HeartbeatListener listening the heartbeat events of server sessions
+// - Pong received runs on the session read goroutine, or the shared loop goroutine in event loop mode. Never block.
+type HeartbeatListener interface {
+	OnHeartbeat(session *Session, event HeartbeatEvent)
+}
+
+// ClientHeartbeatListener listening the heartbeat events of client
+type ClientHeartbeatListener interface {
+	OnHeartbeat(cli *TCPClient, event HeartbeatEvent)
+}
+
 type SessionListener interface {
 	OnSessionCreate(session *Session)
 	OnSessionClose(session *Session)
 }
+
+// ConnectionRejectListener metrics hook, listening connections rejected before session create.
+type ConnectionRejectListener interface {
+	OnConnectionRejected(remoteAddr string, reason RejectReason)
+}
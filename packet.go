@@ -34,6 +34,9 @@ type Packet struct {
 	// Checksum             //=
 	checksum uint32 //////////=
 	// ==== Checksum end ======
+
+	buf  *[]byte // Holder of the pooled body, nil if not pooled
+	refs int32   // References of the pooled body, see Retain, Release
 }
 
 func NewPacket(ver byte, len uint32, packet []byte, checksum uint32) *Packet {
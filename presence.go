@@ -0,0 +1,161 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"errors"
+	"sync"
+)
+
+// PresenceListener listening users online/offline.
+// - OnUserOnline: the first session of the user bound.
+// - OnUserOffline: the last session of the user unbound or closed.
+type PresenceListener interface {
+	OnUserOnline(userID string, session *Session)
+	OnUserOffline(userID string, session *Session)
+}
+
+// identityIndex userID -> sessions of the user
+type identityIndex struct {
+	users map[string]map[string]*Session // userID -> sID -> session
+	mu    sync.RWMutex
+}
+
+func newIdentityIndex() *identityIndex {
+	return &identityIndex{users: make(map[string]map[string]*Session)}
+}
+
+// bind return true if it is the first session of the user
+func (idx *identityIndex) bind(userID string, s *Session) bool {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	sessions := idx.users[userID]
+	if sessions == nil {
+		sessions = make(map[string]*Session)
+		idx.users[userID] = sessions
+	}
+	sessions[s.sID] = s
+
+	return len(sessions) == 1
+}
+
+// unbind return true if it was the last session of the user
+func (idx *identityIndex) unbind(userID string, s *Session) bool {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	sessions := idx.users[userID]
+	if _, ok := sessions[s.sID]; !ok {
+		return false
+	}
+	if delete(sessions, s.sID); len(sessions) == 0 {
+		delete(idx.users, userID)
+		return true
+	}
+	return false
+}
+
+func (idx *identityIndex) sessions(userID string) []*Session {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	sessions := make([]*Session, 0, len(idx.users[userID]))
+	for _, s := range idx.users[userID] {
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+func (idx *identityIndex) userIDs() []string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	userIDs := make([]string, 0, len(idx.users))
+	for userID := range idx.users {
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs
+}
+
+// BindUser map the session to the user. A user can have many sessions, a session one user.
+// - The session of a string identity (see Authenticator) is bound on create automatically.
+func (ts *TCPServer) BindUser(s *Session, userID string) error {
+	if userID == "" {
+		return errors.New("Empty userID. ")
+	}
+
+	s.mu.Lock()
+	if s.status == statusClosed {
+		s.mu.Unlock()
+		return errors.New("Session closed. ")
+	}
+	old := s.userID
+	s.userID = userID
+	s.mu.Unlock()
+
+	if old == userID {
+		return nil
+	}
+	if old != "" {
+		ts.unbindUser(s, old)
+	}
+
+	if ts.identities.bind(userID, s) && ts.presenceListener != nil {
+		ts.safeCall(s, "PresenceListener.OnUserOnline", func() { ts.presenceListener.OnUserOnline(userID, s) })
+	}
+
+	// Closed meanwhile, the close may have unbound before the bind above. Undo it, unbind again is no-op.
+	if s.IsClosed() {
+		ts.unbindUser(s, userID)
+		return errors.New("Session closed. ")
+	}
+	return nil
+}
+
+// UnbindUser remove the session from its user
+func (ts *TCPServer) UnbindUser(s *Session) {
+	s.mu.Lock()
+	userID := s.userID
+	s.userID = ""
+	s.mu.Unlock()
+
+	if userID != "" {
+		ts.unbindUser(s, userID)
+	}
+}
+
+func (ts *TCPServer) unbindUser(s *Session, userID string) {
+	if ts.identities.unbind(userID, s) && ts.presenceListener != nil {
+		ts.safeCall(s, "PresenceListener.OnUserOffline", func() { ts.presenceListener.OnUserOffline(userID, s) })
+	}
+}
+
+// UserSessions return the sessions of the user
+func (ts *TCPServer) UserSessions(userID string) []*Session {
+	return ts.identities.sessions(userID)
+}
+
+// SendToUser send the message to every session of the user. Return the count of sessions the message queued to.
+// - Not block: a session closed or with its send queue full is skipped.
+func (ts *TCPServer) SendToUser(userID string, message interface{}) int {
+	queued := 0
+	for _, s := range ts.identities.sessions(userID) {
+		if s.trySend(message) {
+			queued++
+		}
+	}
+	return queued
+}
+
+// IsOnline return the user has any session
+func (ts *TCPServer) IsOnline(userID string) bool {
+	return len(ts.identities.sessions(userID)) > 0
+}
+
+// OnlineUsers return the userIDs have any session
+func (ts *TCPServer) OnlineUsers() []string {
+	return ts.identities.userIDs()
+}
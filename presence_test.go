@@ -0,0 +1,100 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"net"
+	"sync"
+	"testing"
+)
+
+type testPresenceListener struct {
+	events []string
+}
+
+func (l *testPresenceListener) OnUserOnline(userID string, _ *Session) {
+	l.events = append(l.events, "online:"+userID)
+}
+
+func (l *testPresenceListener) OnUserOffline(userID string, _ *Session) {
+	l.events = append(l.events, "offline:"+userID)
+}
+
+func TestPresence(t *testing.T) {
+	presence := &testPresenceListener{}
+	server := NewTCPServer("127.0.0.1:0").
+		RegisterMessageListener(&TestExampleServerMessageListener{}).
+		RegisterPresenceListener(presence)
+
+	phone := NewSession(nil, 0, 0, 0, server)
+	laptop := NewSession(nil, 0, 0, 0, server)
+
+	_ = server.BindUser(phone, "42")
+	_ = server.BindUser(laptop, "42")
+
+	if !server.IsOnline("42") || len(server.UserSessions("42")) != 2 || phone.UserID() != "42" {
+		t.Fatal("expect user 42 online with 2 sessions")
+	}
+
+	server.UnbindUser(phone)
+	if !server.IsOnline("42") {
+		t.Fatal("expect user 42 still online with laptop")
+	}
+
+	// Rebind laptop to another user, 42 goes offline.
+	_ = server.BindUser(laptop, "43")
+	if server.IsOnline("42") || !server.IsOnline("43") {
+		t.Fatal("expect 42 offline, 43 online")
+	}
+
+	want := []string{"online:42", "offline:42", "online:43"}
+	if len(presence.events) != len(want) {
+		t.Fatalf("expect events %v, got %v", want, presence.events)
+	}
+	for i := range want {
+		if presence.events[i] != want[i] {
+			t.Fatalf("expect events %v, got %v", want, presence.events)
+		}
+	}
+}
+
+// Bind racing the session close, the user must not stay bound to the closed session.
+func TestBindUserClosed(t *testing.T) {
+	server := NewTCPServer("127.0.0.1:0").SetDebugMode(false)
+
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	conn, err := net.DialTCP("tcp", nil, ln.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for i := 0; i < 1000; i++ {
+		s := NewSession(conn, 0, 0, 0, server)
+
+		start := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			_ = server.BindUser(s, "42")
+		}()
+
+		// As the session teardown
+		close(start)
+		s.CloseSession("Bye.")
+		server.UnbindUser(s)
+		wg.Wait()
+
+		if server.IsOnline("42") {
+			t.Fatalf("round %d: expect user offline after the session closed", i)
+		}
+	}
+}
@@ -0,0 +1,477 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+//go:build linux
+// +build linux
+
+package gosocket
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	eventLoopReadBufSize = 64 * 1024 // Read buffer of each loop, shared by its sessions
+	eventLoopMaxEvents   = 256       // Max ready events per epoll wait
+	eventLoopWaitMillis  = 100       // Epoll wait timeout, to check the stop
+)
+
+var eventLoopWakeByte = []byte{1}
+
+// reactor the epoll event loops read all the sessions in event loop mode, see TCPServer.SetEventLoop.
+// - A session is added to one loop round-robin. The loop read its ready bytes and decode the packets without blocking.
+// - The messages go to the worker pool, so a slow listener not block the other sessions of the loop.
+// - The loop never sleep nor write. A rate limited session, or one its worker queue full, is paused: removed from
+// the epoll until resumed by the time wheel, the other sessions of the loop keep reading.
+type reactor struct {
+	loops []*eventLoop
+	next  uint32
+	wg    sync.WaitGroup
+}
+
+type eventLoop struct {
+	epfd    int
+	wakeR   int // Wake pipe, signaled when paused sessions resumed
+	wakeW   int
+	conns   map[int]*loopConn
+	resumed []*loopConn // Resumed by the time wheel, handled on the loop
+	buf     []byte
+	server  *TCPServer
+	begin   time.Time // Loop clock of the read deadlines, monotonic
+	quit    int32     // atomic
+	closed  bool      // The fds closed, guarded by mu. Not touched after.
+	mu      sync.Mutex
+}
+
+// loopConn a session in the event loop. in holds the bytes of the incomplete packet.
+// - Only its loop goroutine touch the read state, the conn is out of the epoll while paused.
+type loopConn struct {
+	partial  int64 // atomic. Loop clock the incomplete packet in began, 0 none. First for 64-bit alignment.
+	s        *Session
+	ctx      context.Context
+	raw      syscall.RawConn
+	fd       int
+	loop     *eventLoop
+	in       []byte
+	paused   bool
+	delayed  *Packet       // RateLimitDelay packet, received on resume
+	wait     time.Duration // Pause of the delayed packet
+	held     []heldJob     // Dispatch jobs the worker queue was full for, queued first on resume
+	resume   wheelTimer
+	deadline wheelTimer // Read deadline of the incomplete packet
+}
+
+type heldJob struct {
+	q   chan dispatchJob
+	job dispatchJob
+}
+
+// tryQueue queue the job without blocking, return false if the worker queue still full
+func (h heldJob) tryQueue() bool {
+	select {
+	case h.q <- h.job:
+		return true
+	default:
+		return false
+	}
+}
+
+func newReactor(ts *TCPServer, loops int) (*reactor, error) {
+	r := &reactor{loops: make([]*eventLoop, 0, loops)}
+	for i := 0; i < loops; i++ {
+		l, err := newEventLoop(ts)
+		if err != nil {
+			for _, l := range r.loops {
+				l.closeFds()
+			}
+			return nil, err
+		}
+		r.loops = append(r.loops, l)
+	}
+	return r, nil
+}
+
+func newEventLoop(ts *TCPServer) (*eventLoop, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("Event loop epoll create error. %v", err)
+	}
+
+	var wake [2]int
+	if err := syscall.Pipe2(wake[:], syscall.O_NONBLOCK|syscall.O_CLOEXEC); err != nil {
+		_ = syscall.Close(epfd)
+		return nil, fmt.Errorf("Event loop wake pipe error. %v", err)
+	}
+
+	l := &eventLoop{
+		epfd:   epfd,
+		wakeR:  wake[0],
+		wakeW:  wake[1],
+		conns:  make(map[int]*loopConn),
+		buf:    make([]byte, eventLoopReadBufSize),
+		server: ts,
+		begin:  time.Now(),
+	}
+
+	event := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(l.wakeR)}
+	if err := syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, l.wakeR, &event); err != nil {
+		l.closeFds()
+		return nil, fmt.Errorf("Event loop wake pipe add error. %v", err)
+	}
+	return l, nil
+}
+
+func (l *eventLoop) closeFds() {
+	_ = syscall.Close(l.epfd)
+	_ = syscall.Close(l.wakeR)
+	_ = syscall.Close(l.wakeW)
+}
+
+func (r *reactor) start() {
+	for _, l := range r.loops {
+		r.wg.Add(1)
+		go func(l *eventLoop) {
+			defer r.wg.Done()
+			l.run()
+		}(l)
+	}
+}
+
+// stop the loops and close their fds. The sessions still closing may remove after, it is no-op then.
+func (r *reactor) stop() {
+	for _, l := range r.loops {
+		atomic.StoreInt32(&l.quit, 1)
+	}
+	r.wg.Wait()
+
+	for _, l := range r.loops {
+		l.mu.Lock()
+		l.closed = true
+		l.closeFds()
+		l.mu.Unlock()
+	}
+}
+
+// add the session to a loop, its packets are read by the loop until removed.
+func (r *reactor) add(ctx context.Context, s *Session) (*loopConn, error) {
+	raw, err := s.conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var fd int
+	if err := raw.Control(func(f uintptr) { fd = int(f) }); err != nil {
+		return nil, err
+	}
+
+	l := r.loops[atomic.AddUint32(&r.next, 1)%uint32(len(r.loops))]
+	lc := &loopConn{s: s, raw: raw, fd: fd, loop: l}
+	lc.ctx = context.WithValue(ctx, loopReaderKey{}, lc)
+	lc.resume = wheelTimer{fn: func() { l.wake(lc) }, wheel: l.server.timeWheel}
+	lc.deadline = wheelTimer{fn: func() { l.expire(lc) }, wheel: l.server.timeWheel}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return nil, fmt.Errorf("Event loop closed. ")
+	}
+	if err := l.arm(lc); err != nil {
+		return nil, err
+	}
+	l.conns[fd] = lc
+	return lc, nil
+}
+
+// remove the session from its loop. Called before the conn closed.
+func (r *reactor) remove(lc *loopConn) {
+	lc.loop.remove(lc)
+}
+
+func (l *eventLoop) remove(lc *loopConn) {
+	if lc.resume.stop() { // Paused and not woken, the loop not touch it any more
+		lc.discardHeld()
+	}
+	lc.deadline.stop()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.closed && l.conns[lc.fd] == lc {
+		delete(l.conns, lc.fd)
+		_ = syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_DEL, lc.fd, nil) // ENOENT if paused
+	}
+}
+
+// arm add the conn to the epoll. Called with mu held.
+func (l *eventLoop) arm(lc *loopConn) error {
+	event := syscall.EpollEvent{Events: syscall.EPOLLIN | syscall.EPOLLRDHUP, Fd: int32(lc.fd)}
+	return syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_ADD, lc.fd, &event)
+}
+
+func (l *eventLoop) run() {
+	events := make([]syscall.EpollEvent, eventLoopMaxEvents)
+
+	for atomic.LoadInt32(&l.quit) == 0 {
+		n, err := syscall.EpollWait(l.epfd, events, eventLoopWaitMillis)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			l.server.logger.Printf("Event loop epoll wait error. %v", err)
+			return
+		}
+
+		for i := 0; i < n; i++ {
+			fd := int(events[i].Fd)
+			if fd == l.wakeR {
+				l.onWake()
+				continue
+			}
+
+			l.mu.Lock()
+			lc := l.conns[fd]
+			l.mu.Unlock()
+
+			if lc != nil && !lc.paused { // Paused in this round
+				l.onReadable(lc)
+			}
+		}
+	}
+}
+
+// onReadable read the bytes ready, and handle the whole packets in them.
+func (l *eventLoop) onReadable(lc *loopConn) {
+	var n int
+	var readErr error
+	err := lc.raw.Read(func(fd uintptr) bool {
+		n, readErr = syscall.Read(int(fd), l.buf)
+		return true // Not wait, the loop wait by epoll
+	})
+	if err == nil {
+		err = readErr
+	}
+
+	switch {
+	case err == syscall.EAGAIN || err == syscall.EINTR:
+		return
+	case err != nil:
+		l.close(lc, CloseFrame{Code: CloseAbnormal, Reason: fmt.Sprint("Read close. ", err)})
+		return
+	case n == 0:
+		l.close(lc, CloseFrame{Code: CloseAbnormal, Reason: "Session EOF. "})
+		return
+	}
+
+	// Decode from the loop buffer directly if nothing pending
+	data := l.buf[:n]
+	if len(lc.in) > 0 {
+		lc.in = append(lc.in, data...)
+		data = lc.in
+	}
+	l.process(lc, data)
+}
+
+// process handle the whole packets in data, until the session paused. The rest kept in lc.in.
+func (l *eventLoop) process(lc *loopConn, data []byte) {
+	tcpSer := l.server
+	handler := defaultConnectHandler{}
+	for !lc.pausing() {
+		pac, consumed, err := decodePacket(data, tcpSer.maxPacketBodyLen, tcpSer.bufferPooling)
+		if err != nil {
+			l.close(lc, err.(CloseFrame))
+			return
+		}
+		if pac == nil {
+			break
+		}
+		data = data[consumed:]
+
+		ok := handler.handlePacket(lc.ctx, pac, lc.s, tcpSer)
+		pac.Release()
+		if !ok {
+			l.remove(lc)
+			return
+		}
+	}
+
+	// Keep the incomplete packet, the loop buffer is reused
+	if len(data) == 0 {
+		lc.in = lc.in[:0]
+	} else {
+		lc.in = append(lc.in[:0], data...)
+	}
+	l.watchDeadline(lc)
+
+	if lc.pausing() {
+		l.pause(lc)
+	}
+}
+
+// watchDeadline bound the incomplete packet by the session read deadline, as the read goroutine does.
+// - Not counted while paused, the rest bytes are held by the loop, not the peer.
+func (l *eventLoop) watchDeadline(lc *loopConn) {
+	if len(lc.in) == 0 || lc.pausing() || lc.s.readDeadline <= 0 {
+		atomic.StoreInt64(&lc.partial, 0)
+		return
+	}
+	if atomic.LoadInt64(&lc.partial) == 0 {
+		atomic.StoreInt64(&lc.partial, int64(time.Since(l.begin))+1)
+		lc.deadline.reset(lc.s.readDeadline)
+	}
+}
+
+// expire close the session if its incomplete packet not completed in the read deadline. Called on the time wheel goroutine.
+func (l *eventLoop) expire(lc *loopConn) {
+	began := atomic.LoadInt64(&lc.partial)
+	if began == 0 {
+		return
+	}
+	if rest := lc.s.readDeadline - (time.Since(l.begin) - time.Duration(began)); rest > 0 {
+		lc.deadline.reset(rest) // Another packet began after the timer set
+		return
+	}
+	l.close(lc, CloseFrame{Code: CloseAbnormal, Reason: "Read deadline exceeded. "})
+}
+
+// pausing return the packet handled asked to pause the session
+func (lc *loopConn) pausing() bool {
+	return lc.delayed != nil || len(lc.held) > 0
+}
+
+// delay see loopReader, called on the loop by handlePacket
+func (lc *loopConn) delay(packet *Packet, wait time.Duration) {
+	packet.Retain() // Released by the loop after handlePacket returned
+	lc.delayed = packet
+	lc.wait = wait
+}
+
+// hold see loopReader, called on the loop by the dispatcher
+func (lc *loopConn) hold(q chan dispatchJob, job dispatchJob) {
+	lc.held = append(lc.held, heldJob{q: q, job: job})
+}
+
+// discardHeld release the held jobs and the delayed packet of the removed session, they never run.
+func (lc *loopConn) discardHeld() {
+	for i, h := range lc.held {
+		h.job.discard()
+		lc.held[i] = heldJob{}
+	}
+	lc.held = lc.held[:0]
+
+	if pac := lc.delayed; pac != nil {
+		lc.delayed = nil
+		pac.Release()
+	}
+}
+
+// pause remove the conn from the epoll, resumed by the time wheel. The held jobs retried every tick.
+func (l *eventLoop) pause(lc *loopConn) {
+	wait := timeWheelTick
+	if lc.delayed != nil && len(lc.held) == 0 {
+		wait = lc.wait
+	}
+
+	if !lc.paused {
+		lc.paused = true
+
+		l.mu.Lock()
+		if !l.closed && l.conns[lc.fd] == lc {
+			_ = syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_DEL, lc.fd, nil)
+		}
+		l.mu.Unlock()
+	}
+	lc.resume.reset(wait)
+}
+
+// wake queue the session to resume on its loop. Called on the time wheel goroutine.
+func (l *eventLoop) wake(lc *loopConn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return
+	}
+	l.resumed = append(l.resumed, lc)
+	_, _ = syscall.Write(l.wakeW, eventLoopWakeByte) // EAGAIN if already signaled
+}
+
+func (l *eventLoop) onWake() {
+	var drain [64]byte
+	for {
+		if n, _ := syscall.Read(l.wakeR, drain[:]); n < len(drain) {
+			break
+		}
+	}
+
+	l.mu.Lock()
+	resumed := l.resumed
+	l.resumed = nil
+	l.mu.Unlock()
+
+	for _, lc := range resumed {
+		l.mu.Lock()
+		added := l.conns[lc.fd] == lc
+		l.mu.Unlock()
+
+		if !added {
+			lc.discardHeld() // Removed while paused
+		} else if lc.paused {
+			l.resumeConn(lc)
+		}
+	}
+}
+
+// resumeConn continue the paused session: queue the held jobs, receive the delayed packet, handle the rest bytes,
+// then add it back to the epoll.
+func (l *eventLoop) resumeConn(lc *loopConn) {
+	queued := 0
+	for queued < len(lc.held) && lc.held[queued].tryQueue() {
+		queued++
+	}
+	rest := copy(lc.held, lc.held[queued:])
+	for i := rest; i < len(lc.held); i++ {
+		lc.held[i] = heldJob{}
+	}
+	if lc.held = lc.held[:rest]; rest > 0 {
+		l.pause(lc) // Still full, retry the next tick
+		return
+	}
+
+	if pac := lc.delayed; pac != nil {
+		lc.delayed = nil
+		defaultConnectHandler{}.receivePacket(lc.ctx, pac, lc.s, l.server)
+		pac.Release()
+		if lc.pausing() {
+			l.pause(lc)
+			return
+		}
+	}
+
+	lc.paused = false
+	l.process(lc, lc.in)
+	if lc.paused {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.closed && l.conns[lc.fd] == lc {
+		if err := l.arm(lc); err != nil {
+			l.server.logger.Printf("Event loop resume error. sID: %s, %v", lc.s.sID, err)
+		}
+	}
+}
+
+// close remove the conn from the loop and close the session
+func (l *eventLoop) close(lc *loopConn, frame CloseFrame) {
+	l.remove(lc)
+	lc.s.CloseSessionWithCode(frame.Code, frame.Reason)
+}
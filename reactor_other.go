@@ -0,0 +1,32 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+//go:build !linux
+// +build !linux
+
+package gosocket
+
+import (
+	"context"
+	"errors"
+)
+
+// reactor event loop mode is only supported on Linux (epoll), see TCPServer.SetEventLoop.
+type reactor struct{}
+
+type loopConn struct{}
+
+func newReactor(_ *TCPServer, _ int) (*reactor, error) {
+	return nil, errors.New("Event loop mode only supported on Linux. ")
+}
+
+func (r *reactor) start() {}
+
+func (r *reactor) stop() {}
+
+func (r *reactor) add(_ context.Context, _ *Session) (*loopConn, error) {
+	return nil, errors.New("Event loop mode only supported on Linux. ")
+}
+
+func (r *reactor) remove(_ *loopConn) {}
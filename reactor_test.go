@@ -0,0 +1,291 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventLoop(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Event loop mode only supported on Linux.")
+	}
+
+	received := make(chan interface{}, 16)
+	sessions := testCloseFrameSessionListener{created: make(chan *Session, 1), closed: make(chan CloseFrame, 1)}
+
+	server, err := NewTCPServer("127.0.0.1:18849").
+		RegisterMessageListener(testChanServerListener{received: received}).
+		RegisterSessionListener(sessions).
+		SetEventLoop(2).
+		SetBufferPooling(true).
+		SetDebugMode(false).
+		Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	clientReceived := make(chan interface{}, 4)
+	client, err := NewTcpClient("127.0.0.1:18849").
+		RegisterMessageListener(testChanClientListener{received: clientReceived}).
+		SetDebugMode(false).
+		Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := <-sessions.created
+
+	// Larger than the loop read buffer (64KB), read over several readiness events
+	large := strings.Repeat("x", 3*64*1024+7)
+	expect := []string{"first", large, "last"}
+	for _, msg := range expect {
+		if err := client.SendMessage(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, msg := range expect {
+		select {
+		case got := <-received:
+			if got != msg {
+				t.Fatalf("expect message len %d, got len %d", len(msg), len(got.(string)))
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("timeout waiting message")
+		}
+	}
+
+	// Write and control packets through the session goroutine
+	s.SendMessage("Hi!")
+	select {
+	case got := <-clientReceived:
+		if got != "Hi!" {
+			t.Fatalf("expect Hi!, got %v", got)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting server message")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if _, err := client.Ping(ctx); err != nil {
+		t.Fatalf("client ping: %v", err)
+	}
+	if _, err := s.Ping(ctx); err != nil {
+		t.Fatalf("session ping: %v", err)
+	}
+
+	// Closed by the client, the loop read the close packet
+	client.Hangup("Bye.")
+	select {
+	case frame := <-sessions.closed:
+		if frame.Code != CloseNormal || frame.Reason != "Bye." {
+			t.Fatalf("expect CloseNormal Bye., got %v", frame)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting session close")
+	}
+}
+
+// testSlowListener block the worker on every message, until the gate closed
+type testSlowListener struct {
+	gate     chan struct{}
+	received chan interface{}
+}
+
+func (l testSlowListener) OnMessage(_ context.Context, message interface{}, _ *Session) {
+	<-l.gate
+	l.received <- message
+}
+
+// dialEventLoopClient dial and return the client with its session on the server
+func dialEventLoopClient(t *testing.T, addr string, created chan *Session) (*TCPClient, *Session) {
+	client, err := NewTcpClient(addr).
+		RegisterMessageListener(&TestExampleClientListener{}).
+		SetDebugMode(false).
+		Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case s := <-created:
+		return client, s
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting session create")
+	}
+	return nil, nil
+}
+
+func TestEventLoopRateLimitNotBlock(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Event loop mode only supported on Linux.")
+	}
+
+	received := make(chan interface{}, 16)
+	sessions := testCloseFrameSessionListener{created: make(chan *Session, 2), closed: make(chan CloseFrame, 2)}
+
+	server, err := NewTCPServer("127.0.0.1:18852").
+		RegisterMessageListener(testChanServerListener{received: received}).
+		RegisterSessionListener(sessions).
+		SetEventLoop(1). // Both sessions on the same loop
+		SetDebugMode(false).
+		Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	limited, s := dialEventLoopClient(t, "127.0.0.1:18852", sessions.created)
+	defer limited.Hangup("Test done.")
+	s.SetRateLimit(RateLimit{MessagesPerSecond: 5, MessagesBurst: 1, Policy: RateLimitDelay})
+
+	normal, _ := dialEventLoopClient(t, "127.0.0.1:18852", sessions.created)
+	defer normal.Hangup("Test done.")
+
+	// About 1s to receive all, one message per 200ms
+	begin := time.Now()
+	for i := 0; i < 6; i++ {
+		if err := limited.SendMessage(fmt.Sprintf("limited-%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// The normal session on the same loop not wait the limited one
+	if err := normal.SendMessage("normal"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if rtt, err := normal.Ping(ctx); err != nil || rtt > 150*time.Millisecond {
+		t.Fatalf("expect normal session ping in 150ms, got %v %v", rtt, err)
+	}
+
+	next := 0
+	for next < 6 {
+		select {
+		case got := <-received:
+			if got == "normal" {
+				if time.Since(begin) > 300*time.Millisecond {
+					t.Fatalf("normal message waited the limited session, %v, %d limited received", time.Since(begin), next)
+				}
+				continue
+			}
+			if expect := fmt.Sprintf("limited-%d", next); got != expect {
+				t.Fatalf("expect %s, got %v", expect, got)
+			}
+			next++
+		case <-time.After(3 * time.Second):
+			t.Fatalf("timeout waiting limited messages, %d received", next)
+		}
+	}
+	if elapsed := time.Since(begin); elapsed < 800*time.Millisecond {
+		t.Fatalf("expect limited messages delayed about 1s, got %v", elapsed)
+	}
+}
+
+func TestEventLoopDispatchWaitNotBlock(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Event loop mode only supported on Linux.")
+	}
+
+	gate := make(chan struct{})
+	received := make(chan interface{}, 16)
+	sessions := testCloseFrameSessionListener{created: make(chan *Session, 2), closed: make(chan CloseFrame, 2)}
+
+	server, err := NewTCPServer("127.0.0.1:18853").
+		RegisterMessageListener(testSlowListener{gate: gate, received: received}).
+		RegisterSessionListener(sessions).
+		SetEventLoop(1).
+		SetWorkerPool(1, 1, DispatchWait).
+		SetDebugMode(false).
+		Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	busy, _ := dialEventLoopClient(t, "127.0.0.1:18853", sessions.created)
+	defer busy.Hangup("Test done.")
+	other, _ := dialEventLoopClient(t, "127.0.0.1:18853", sessions.created)
+	defer other.Hangup("Test done.")
+
+	// The worker blocked and its queue full, the rest held by the loop
+	for i := 0; i < 8; i++ {
+		if err := busy.SendMessage(fmt.Sprintf("busy-%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// The loop still read the other session
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if rtt, err := other.Ping(ctx); err != nil || rtt > 150*time.Millisecond {
+		t.Fatalf("expect other session ping in 150ms, got %v %v", rtt, err)
+	}
+
+	// Nothing dropped, in order
+	close(gate)
+	for i := 0; i < 8; i++ {
+		select {
+		case got := <-received:
+			if expect := fmt.Sprintf("busy-%d", i); got != expect {
+				t.Fatalf("expect %s, got %v", expect, got)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("timeout waiting held messages, %d received", i)
+		}
+	}
+}
+
+func TestEventLoopReadDeadline(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Event loop mode only supported on Linux.")
+	}
+
+	sessions := testCloseFrameSessionListener{created: make(chan *Session, 1), closed: make(chan CloseFrame, 1)}
+	server, err := NewTCPServer("127.0.0.1:18854").
+		RegisterMessageListener(testChanServerListener{received: make(chan interface{}, 1)}).
+		RegisterSessionListener(sessions).
+		SetHeartbeat(200 * time.Millisecond).
+		SetDefaultSessionReadDeadline(300 * time.Millisecond).
+		SetEventLoop(1).
+		SetDebugMode(false).
+		Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	conn, err := net.Dial("tcp", "127.0.0.1:18854")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	<-sessions.created
+
+	// The version and part of the size, the rest never sent
+	if _, err := conn.Write([]byte{PacketVersion, 0, 0}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case frame := <-sessions.closed:
+		if frame.Reason != "Read deadline exceeded. " {
+			t.Fatalf("expect read deadline close, got %+v", frame)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("incomplete packet not bounded by the read deadline")
+	}
+}
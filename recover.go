@@ -0,0 +1,77 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicPolicy what happens to the session after a user callback panic recovered
+type PanicPolicy int
+
+const (
+	// PanicPolicyCloseSession close the session the panic happened on
+	PanicPolicyCloseSession PanicPolicy = iota
+	// PanicPolicyContinue keep the session running, only the panicked callback is aborted
+	PanicPolicyContinue
+)
+
+// PanicListener listening panics recovered from user callbacks (listeners, codec, authenticator).
+// - session is nil if the panic not happened on a session.
+type PanicListener interface {
+	OnPanic(session *Session, recovered interface{}, stack []byte)
+}
+
+// safeCall run the user callback fn with a recovery boundary, and apply the panic policy.
+// Return false if fn panicked.
+func (ts *TCPServer) safeCall(s *Session, callback string, fn func()) (ok bool) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		ok = false
+
+		stack := debug.Stack()
+		sID := ""
+		if s != nil {
+			sID = s.sID
+		}
+		ts.logger.Printf("Recovered panic in %s. sID: %s, panic: %v\n%s", callback, sID, r, stack)
+
+		if ts.panicListener != nil {
+			func() {
+				defer func() {
+					if r2 := recover(); r2 != nil {
+						ts.logger.Printf("Recovered panic in PanicListener. sID: %s, panic: %v", sID, r2)
+					}
+				}()
+				ts.panicListener.OnPanic(s, r, stack)
+			}()
+		}
+
+		if s != nil && ts.panicPolicy == PanicPolicyCloseSession {
+			s.CloseSessionWithCode(CloseInternalError, fmt.Sprintf("Panic in %s: %v", callback, r))
+		}
+	}()
+
+	fn()
+	return true
+}
+
+// safeCall run the client user callback fn with a recovery boundary, the connection keeps running.
+// Return false if fn panicked.
+func (cli *TCPClient) safeCall(callback string, fn func()) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			ok = false
+			cli.logger.Printf("Client %s recovered panic in %s. panic: %v\n%s", cli.name, callback, r, debug.Stack())
+		}
+	}()
+
+	fn()
+	return true
+}
@@ -0,0 +1,81 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type testPanicMessageListener struct{}
+
+func (t testPanicMessageListener) OnMessage(_ context.Context, message interface{}, _ *Session) {
+	panic(message)
+}
+
+type testPanicListener struct {
+	panics chan interface{}
+}
+
+func (t testPanicListener) OnPanic(_ *Session, recovered interface{}, stack []byte) {
+	if len(stack) == 0 {
+		recovered = "no stack"
+	}
+	t.panics <- recovered
+}
+
+type testCloseSessionListener struct {
+	closed chan string
+}
+
+func (t testCloseSessionListener) OnSessionCreate(_ *Session) {}
+
+func (t testCloseSessionListener) OnSessionClose(s *Session) {
+	t.closed <- s.SID()
+}
+
+func TestPanicRecovery(t *testing.T) {
+	panics := make(chan interface{}, 1)
+	closed := make(chan string, 1)
+
+	server, err := NewTCPServer("127.0.0.1:18831").
+		RegisterMessageListener(testPanicMessageListener{}).
+		RegisterSessionListener(testCloseSessionListener{closed: closed}).
+		RegisterPanicListener(testPanicListener{panics: panics}).
+		SetDebugMode(false).
+		Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	client, err := NewTcpClient("127.0.0.1:18831").
+		RegisterMessageListener(&TestExampleClientListener{}).
+		SetDebugMode(false).
+		Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Hangup("Test done.")
+
+	_ = client.SendMessage("boom")
+
+	select {
+	case r := <-panics:
+		if r != "boom" {
+			t.Fatalf("expect recovered boom, got %v", r)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("panic not recovered")
+	}
+
+	// PanicPolicyCloseSession: the session closed, the server still running.
+	select {
+	case <-closed:
+	case <-time.After(3 * time.Second):
+		t.Fatal("session not closed after panic")
+	}
+}
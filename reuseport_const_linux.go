@@ -0,0 +1,12 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+//go:build linux && (386 || amd64 || arm)
+// +build linux
+// +build 386 amd64 arm
+
+package gosocket
+
+// soReusePort SO_REUSEPORT of the generic Linux ABI, not defined by the syscall package on these arches
+const soReusePort = 0xf
@@ -0,0 +1,21 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+//go:build linux
+// +build linux
+
+package gosocket
+
+import "syscall"
+
+// reusePortControl set SO_REUSEPORT on the listen socket before bind, see TCPServer.SetReusePort
+func reusePortControl(_, _ string, c syscall.RawConn) error {
+	var sockErr error
+	if err := c.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+	}); err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -0,0 +1,18 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+//go:build !linux
+// +build !linux
+
+package gosocket
+
+import (
+	"errors"
+	"syscall"
+)
+
+// reusePortControl SO_REUSEPORT listeners are only supported on Linux, see TCPServer.SetReusePort
+func reusePortControl(_, _ string, _ syscall.RawConn) error {
+	return errors.New("SO_REUSEPORT listeners only supported on Linux. ")
+}
@@ -0,0 +1,13 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+//go:build linux && !386 && !amd64 && !arm
+// +build linux,!386,!amd64,!arm
+
+package gosocket
+
+import "syscall"
+
+// soReusePort SO_REUSEPORT defined by the syscall package, differs by arch (mips: 0x200)
+const soReusePort = syscall.SO_REUSEPORT
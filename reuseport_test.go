@@ -0,0 +1,51 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestReusePort(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("SO_REUSEPORT listeners only supported on Linux.")
+	}
+
+	server, err := NewTCPServer("127.0.0.1:18850").
+		RegisterMessageListener(&TestExampleServerMessageListener{}).
+		SetReusePort(4).
+		SetDebugMode(false).
+		Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	if len(server.listeners) != 4 {
+		t.Fatalf("expect 4 listeners, got %d", len(server.listeners))
+	}
+
+	const clients = 16
+	for i := 0; i < clients; i++ {
+		client, err := NewTcpClient("127.0.0.1:18850").
+			RegisterMessageListener(&TestExampleClientListener{}).
+			SetDebugMode(false).
+			Dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Hangup("Test done.")
+	}
+
+	// All listeners feed the same session registry
+	for deadline := time.Now().Add(3 * time.Second); len(server.Sessions()) < clients; {
+		if time.Now().After(deadline) {
+			t.Fatalf("expect %d sessions, got %d", clients, len(server.Sessions()))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"context"
+	"reflect"
+	"sync"
+)
+
+// Routable a message carry its own route
+type Routable interface {
+	Route() string
+}
+
+// HandlerFunc handle one routed message
+type HandlerFunc func(ctx context.Context, message interface{}, session *Session)
+
+// Middleware wrap a HandlerFunc, e.g. logging, auth check, metrics.
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// Router a MessageListener dispatch messages to handlers by string route or by Go type.
+// - Route of a message: Routable.Route(), a string message is the route itself. Custom by SetRouteFunc.
+// - Match order: string route, Go type, fallback.
+// Usage:
+//
+//	router := NewRouter().
+//		Use(loggingMiddleware).
+//		Handle("Hello!", func(ctx context.Context, message interface{}, session *Session) {
+//			session.SendMessage("Hi!")
+//		}).
+//		HandleType(&ChatMessage{}, onChat, authMiddleware).
+//		Fallback(onUnknown)
+//
+//	server, _ := NewTCPServer("[::1]:8888").RegisterMessageListener(router).Run()
+type Router struct {
+	routes      map[string]HandlerFunc
+	types       map[reflect.Type]HandlerFunc
+	middlewares []Middleware
+	fallback    HandlerFunc
+	routeFunc   func(message interface{}) (string, bool)
+	mu          sync.RWMutex
+}
+
+func NewRouter() *Router {
+	return &Router{
+		routes:    make(map[string]HandlerFunc),
+		types:     make(map[reflect.Type]HandlerFunc),
+		routeFunc: defaultRouteFunc,
+	}
+}
+
+// Use add middlewares applied to every handler, including the fallback.
+func (r *Router) Use(middlewares ...Middleware) *Router {
+	r.mu.Lock()
+	r.middlewares = append(r.middlewares, middlewares...)
+	r.mu.Unlock()
+	return r
+}
+
+// Handle register handler for the string route, with the route middlewares.
+func (r *Router) Handle(route string, handler HandlerFunc, middlewares ...Middleware) *Router {
+	r.mu.Lock()
+	r.routes[route] = chain(handler, middlewares)
+	r.mu.Unlock()
+	return r
+}
+
+// HandleType register handler for the Go type of sample, with the route middlewares.
+// - sample is only used for its type, e.g. HandleType(&ChatMessage{}, ...) match *ChatMessage.
+func (r *Router) HandleType(sample interface{}, handler HandlerFunc, middlewares ...Middleware) *Router {
+	r.mu.Lock()
+	r.types[reflect.TypeOf(sample)] = chain(handler, middlewares)
+	r.mu.Unlock()
+	return r
+}
+
+// Fallback handle the messages no route matched.
+func (r *Router) Fallback(handler HandlerFunc) *Router {
+	r.mu.Lock()
+	r.fallback = handler
+	r.mu.Unlock()
+	return r
+}
+
+// SetRouteFunc custom how to take the route from a decoded message.
+func (r *Router) SetRouteFunc(routeFunc func(message interface{}) (string, bool)) *Router {
+	r.mu.Lock()
+	r.routeFunc = routeFunc
+	r.mu.Unlock()
+	return r
+}
+
+// OnMessage MessageListener impl
+func (r *Router) OnMessage(ctx context.Context, message interface{}, session *Session) {
+	r.mu.RLock()
+	handler := r.match(message)
+	middlewares := r.middlewares
+	r.mu.RUnlock()
+
+	if handler == nil {
+		session.serRef.debugLogger.Printf("Router no route matched. sID: %s, message type: %T", session.sID, message)
+		return
+	}
+
+	chain(handler, middlewares)(ctx, message, session)
+}
+
+func (r *Router) match(message interface{}) HandlerFunc {
+	if route, ok := r.routeFunc(message); ok {
+		if handler, ok := r.routes[route]; ok {
+			return handler
+		}
+	}
+	if handler, ok := r.types[reflect.TypeOf(message)]; ok {
+		return handler
+	}
+	return r.fallback
+}
+
+// chain wrap handler with middlewares, the first middleware is the outermost.
+func chain(handler HandlerFunc, middlewares []Middleware) HandlerFunc {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+func defaultRouteFunc(message interface{}) (string, bool) {
+	switch m := message.(type) {
+	case Routable:
+		return m.Route(), true
+	case string:
+		return m, true
+	}
+	return "", false
+}
@@ -0,0 +1,66 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"context"
+	"testing"
+)
+
+type testChatMessage struct {
+	Text string
+}
+
+type testRoutedMessage struct {
+	route string
+}
+
+func (m testRoutedMessage) Route() string { return m.route }
+
+func TestRouter(t *testing.T) {
+	var got []string
+	record := func(name string) HandlerFunc {
+		return func(_ context.Context, _ interface{}, _ *Session) { got = append(got, name) }
+	}
+	tag := func(name string) Middleware {
+		return func(next HandlerFunc) HandlerFunc {
+			return func(ctx context.Context, message interface{}, session *Session) {
+				got = append(got, name)
+				next(ctx, message, session)
+			}
+		}
+	}
+
+	router := NewRouter().
+		Handle("Hello!", record("hello"), tag("route-mw")).
+		Handle("join", record("join")).
+		HandleType(&testChatMessage{}, record("chat")).
+		Fallback(record("fallback")).
+		Use(tag("global-mw"))
+
+	cases := []struct {
+		message interface{}
+		want    []string
+	}{
+		{"Hello!", []string{"global-mw", "route-mw", "hello"}},
+		{testRoutedMessage{route: "join"}, []string{"global-mw", "join"}},
+		{&testChatMessage{Text: "hi"}, []string{"global-mw", "chat"}},
+		{42, []string{"global-mw", "fallback"}},
+	}
+
+	for _, c := range cases {
+		got = nil
+		router.OnMessage(context.Background(), c.message, nil)
+
+		if len(got) != len(c.want) {
+			t.Fatalf("%v: expect %v, got %v", c.message, c.want, got)
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Fatalf("%v: expect %v, got %v", c.message, c.want, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+// pingPayloadLen ping payload: [seq 32bit][send time unix nano 64bit], echoed back by the pong.
+// - The RTT is measured from the monotonic send time kept by seq, the echoed time is informational only.
+const pingPayloadLen = 12
+
+// maxPendingPings the pings kept waiting the pong, the older ones forgotten
+const maxPendingPings = 64
+
+// RTTStats the round-trip time measured by heartbeat ping/pong
+type RTTStats struct {
+	Last     time.Duration // The last sample. Zero if never measured
+	Smoothed time.Duration // Smoothed average, new = 7/8 old + 1/8 sample, as the TCP SRTT
+	Samples  int           // The count of samples
+}
+
+// rttTracker build the ping packets and measure the RTT from the pong echoed.
+// - Shared by the server session and the client.
+type rttTracker struct {
+	seq     uint32
+	missed  int // Heartbeat pings sent without pong since the last pong
+	stats   RTTStats
+	pending map[uint32]pendingPing // Pings waiting the pong, by seq
+	mu      sync.Mutex
+}
+
+type pendingPing struct {
+	sent   time.Time          // Monotonic, not the wall clock echoed by the peer
+	waiter chan time.Duration // On-demand Ping waiting the pong, nil for heartbeat
+}
+
+// pingPacket build a ping packet with the next seq. Register the waiter if not nil.
+func (r *rttTracker) pingPacket(waiter chan time.Duration) (*Packet, uint32) {
+	now := time.Now()
+
+	r.mu.Lock()
+	r.seq++
+	seq := r.seq
+	if r.pending == nil {
+		r.pending = make(map[uint32]pendingPing)
+	}
+	r.pending[seq] = pendingPing{sent: now, waiter: waiter}
+	delete(r.pending, seq-maxPendingPings) // Never ponged
+	r.mu.Unlock()
+
+	payload := make([]byte, pingPayloadLen)
+	binary.BigEndian.PutUint32(payload, seq)
+	binary.BigEndian.PutUint64(payload[4:], uint64(now.UnixNano()))
+
+	return NewControlPacket(HeartbeatCmdPing, payload), seq
+}
+
+// heartbeatPing build a heartbeat ping packet, and count it missed until the pong received.
+func (r *rttTracker) heartbeatPing() (*Packet, int) {
+	pac, _ := r.pingPacket(nil)
+
+	r.mu.Lock()
+	r.missed++
+	missed := r.missed
+	r.mu.Unlock()
+
+	return pac, missed
+}
+
+// resetMissed reset the missed count on a new connection
+func (r *rttTracker) resetMissed() {
+	r.mu.Lock()
+	r.missed = 0
+	r.mu.Unlock()
+}
+
+// missedPongs return the count of heartbeat pings without pong
+func (r *rttTracker) missedPongs() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.missed
+}
+
+// onPong reset the missed count, and measure the RTT since the ping of the echoed seq sent.
+// - Return false if the payload has no seq (old peer), or the seq is not a pending ping.
+func (r *rttTracker) onPong(payload []byte) (time.Duration, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.missed = 0
+	if len(payload) < pingPayloadLen {
+		return 0, false
+	}
+
+	seq := binary.BigEndian.Uint32(payload)
+	ping, ok := r.pending[seq]
+	if !ok {
+		return 0, false
+	}
+	delete(r.pending, seq)
+	rtt := time.Since(ping.sent)
+
+	r.stats.Last = rtt
+	if r.stats.Samples == 0 {
+		r.stats.Smoothed = rtt
+	} else {
+		r.stats.Smoothed += (rtt - r.stats.Smoothed) / 8
+	}
+	r.stats.Samples++
+
+	if ping.waiter != nil {
+		ping.waiter <- rtt // Buffered, not block
+	}
+	return rtt, true
+}
+
+func (r *rttTracker) cancel(seq uint32) {
+	r.mu.Lock()
+	delete(r.pending, seq)
+	r.mu.Unlock()
+}
+
+func (r *rttTracker) Stats() RTTStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.stats
+}
+
+// ping send a ping through the ctrl chan, and wait its pong.
+func (r *rttTracker) ping(ctx context.Context, ctrlSendChan chan *Packet) (time.Duration, error) {
+	waiter := make(chan time.Duration, 1)
+	pac, seq := r.pingPacket(waiter)
+	defer r.cancel(seq)
+
+	select {
+	case ctrlSendChan <- pac:
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+
+	select {
+	case rtt := <-waiter:
+		return rtt, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
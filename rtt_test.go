@@ -0,0 +1,77 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestPingRTT(t *testing.T) {
+	sessions := testCloseFrameSessionListener{created: make(chan *Session, 1), closed: make(chan CloseFrame, 1)}
+
+	server, err := NewTCPServer("127.0.0.1:18844").
+		RegisterMessageListener(&TestExampleServerMessageListener{}).
+		RegisterSessionListener(sessions).
+		SetDebugMode(false).
+		Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	client, err := NewTcpClient("127.0.0.1:18844").
+		RegisterMessageListener(&TestExampleClientListener{}).
+		SetDebugMode(false).
+		Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Hangup("Test done.")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	for i := 0; i < 3; i++ {
+		if rtt, err := client.Ping(ctx); err != nil || rtt <= 0 {
+			t.Fatalf("expect client ping rtt, got %v %v", rtt, err)
+		}
+	}
+	if stats := client.RTT(); stats.Samples != 3 || stats.Last <= 0 || stats.Smoothed <= 0 {
+		t.Fatalf("expect 3 client rtt samples, got %+v", stats)
+	}
+
+	s := <-sessions.created
+	if rtt, err := s.Ping(ctx); err != nil || rtt <= 0 {
+		t.Fatalf("expect session ping rtt, got %v %v", rtt, err)
+	}
+	if stats := s.RTT(); stats.Samples != 1 || stats.Last != stats.Smoothed {
+		t.Fatalf("expect 1 session rtt sample, got %+v", stats)
+	}
+}
+
+func TestRTTIgnoreEchoedTime(t *testing.T) {
+	var r rttTracker
+	pac, _ := r.heartbeatPing()
+
+	// The peer echo a forged send time an hour ago
+	payload := append([]byte(nil), pac.body[1:]...)
+	binary.BigEndian.PutUint64(payload[4:], uint64(time.Now().Add(-time.Hour).UnixNano()))
+
+	rtt, ok := r.onPong(payload)
+	if !ok || rtt > time.Second {
+		t.Fatalf("expect RTT from the local send time, got %v %v", rtt, ok)
+	}
+	if r.missedPongs() != 0 {
+		t.Fatalf("expect missed reset, got %d", r.missedPongs())
+	}
+
+	// Echoed again, or a seq never sent
+	if _, ok := r.onPong(payload); ok {
+		t.Fatal("expect the seq already ponged not measured again")
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"runtime"
 	"sync"
 	"time"
 )
@@ -21,6 +22,8 @@ type TCPServer struct {
 	defaultWriteDeadline time.Duration       // Server session default write deadline (As default at session creation)
 	defaultHeartbeat     time.Duration       // Server session default heartbeat (As default at session creation)
 	listener             *net.TCPListener    // Server net listener "127.0.0.1:5555" or "[::1]:8888"
+	listeners            []*net.TCPListener  // Server all net listeners, the first is listener
+	reusePort            int                 // Server SO_REUSEPORT listeners count, each with an accept loop (0, 1: one listener)
 	addr                 string              // Server listen address
 	maxPacketBodyLen     uint32              // Server send/receive packet max body length limit (byte)
 	debugLogger          DebugLogger         // Server debug logger
@@ -30,8 +33,37 @@ type TCPServer struct {
 	packetHandler        PacketHandler       // Server connect on packet receive handler
 	messageListener      MessageListener     // Server message processor
 	sessionListener      SessionListener     // Server session create/close listener
+	sessionObservers     []SessionListener   // Server internal session create/close listeners, e.g. Cluster
+	authenticator        Authenticator       // Server new connect authenticator (nil: no handshake)
+	handshakeTimeout     time.Duration       // Server handshake timeout
+	maxSessions          int                 // Server max sessions limit (0: unlimited)
+	maxSessionsPerIP     int                 // Server max sessions per remote IP limit (0: unlimited)
+	limitPolicy          LimitPolicy         // Server policy on max sessions limit reached
+	connLimiter          *connLimiter        // Server accepted connections counter
+	ipFilter             *IPFilter           // Server CIDR allow/deny rules (nil: permit all)
+	defaultRateLimit     RateLimit           // Server session default inbound rate limit (As default at session creation)
+	defaultIdleTimeout   time.Duration       // Server session default idle timeout (As default at session creation, 0: disable)
+	defaultMaxMissed     int                 // Server session default max missed pongs (As default at session creation, 0: disable)
+	heartbeatListener    HeartbeatListener   // Server session heartbeat events listener
+	workers              int                 // Server message listener worker pool size (0: run inline on read goroutine)
+	workerQueueSize      int                 // Server message listener queue size of each worker
+	dispatchPolicy       DispatchPolicy      // Server policy on worker queue full
+	dispatcher           *dispatcher         // Server message listener worker pool
+	timeWheel            *timeWheel          // Server heartbeat and idle check timer of all sessions
+	bufferPooling        bool                // Server read packet bodies into size-classed pooled buffers
+	eventLoops           int                 // Server epoll event loops reading all sessions (0: a read goroutine per session)
+	reactor              *reactor            // Server event loops, nil if goroutine per session
+	panicPolicy          PanicPolicy         // Server policy on user callback panic
+	panicListener        PanicListener       // Server user callback panic listener
+	topics               *topicRegistry      // Server topic subscriptions
+	subAuthorizer        SubscriptionAuthorizer
+	identities           *identityIndex      // Server userID -> sessions
+	presenceListener     PresenceListener    // Server users online/offline listener
+	rejectListener       ConnectionRejectListener
+	controlHandlers      map[byte]ControlHandler
 	stopSign             chan bool
 	mu                   sync.Mutex
+	sessionsMu           sync.RWMutex
 }
 
 // NewTCPServer create a new tcp server
@@ -93,6 +125,8 @@ func NewTCPServer(addr string) *TCPServer {
 		defaultReadDeadline:  sessionDefaultReadDeadline,
 		defaultHeartbeat:     sessionDefaultHeartbeat,
 		listener:             nil,
+		listeners:            nil,
+		reusePort:            0,
 		addr:                 addr,
 		maxPacketBodyLen:     defaultMaxPacketBodyLength,
 		debugLogger:          DebugLogger{isDebugMode: true, logger: DefaultDebugLogger},
@@ -102,12 +136,77 @@ func NewTCPServer(addr string) *TCPServer {
 		packetHandler:        defaultPacketHandler{},
 		messageListener:      nil,
 		sessionListener:      nil,
+		sessionObservers:     nil,
+		authenticator:        nil,
+		handshakeTimeout:     defaultHandshakeTimeout,
+		maxSessions:          0,
+		maxSessionsPerIP:     0,
+		limitPolicy:          LimitPolicyReject,
+		connLimiter:          nil,
+		ipFilter:             nil,
+		defaultRateLimit:     RateLimit{},
+		defaultIdleTimeout:   0,
+		defaultMaxMissed:     defaultMaxMissedPongs,
+		heartbeatListener:    nil,
+		controlHandlers:      make(map[byte]ControlHandler),
+		workers:              0,
+		workerQueueSize:      defaultWorkerQueueSize,
+		dispatchPolicy:       DispatchWait,
+		dispatcher:           nil,
+		timeWheel:            nil,
+		bufferPooling:        false,
+		eventLoops:           0,
+		reactor:              nil,
+		panicPolicy:          PanicPolicyCloseSession,
+		panicListener:        nil,
+		topics:               newTopicRegistry(),
+		subAuthorizer:        nil,
+		identities:           newIdentityIndex(),
+		presenceListener:     nil,
+		rejectListener:       nil,
 		stopSign:             make(chan bool),
 	}
 }
 
+// Sessions return a snapshot of the server sessions. sID -> Session
 func (ts *TCPServer) Sessions() map[string]*Session {
-	return ts.sessions
+	ts.sessionsMu.RLock()
+	defer ts.sessionsMu.RUnlock()
+
+	sessions := make(map[string]*Session, len(ts.sessions))
+	for k, v := range ts.sessions {
+		sessions[k] = v
+	}
+	return sessions
+}
+
+// Session return the session of sID
+func (ts *TCPServer) Session(sID string) (*Session, bool) {
+	ts.sessionsMu.RLock()
+	defer ts.sessionsMu.RUnlock()
+
+	s, ok := ts.sessions[sID]
+	return s, ok
+}
+
+// SessionCount return the count of the server sessions
+func (ts *TCPServer) SessionCount() int {
+	ts.sessionsMu.RLock()
+	defer ts.sessionsMu.RUnlock()
+
+	return len(ts.sessions)
+}
+
+func (ts *TCPServer) addSession(s *Session) {
+	ts.sessionsMu.Lock()
+	ts.sessions[s.sID] = s
+	ts.sessionsMu.Unlock()
+}
+
+func (ts *TCPServer) removeSession(s *Session) {
+	ts.sessionsMu.Lock()
+	delete(ts.sessions, s.sID)
+	ts.sessionsMu.Unlock()
 }
 
 func (ts *TCPServer) RegisterMessageListener(listener MessageListener) *TCPServer {
@@ -122,6 +221,142 @@ func (ts *TCPServer) RegisterSessionListener(listener SessionListener) *TCPServe
 	return ts
 }
 
+// SetAuthenticator authenticate every new connection before session create.
+// - The client need the matching ClientCredentialProvider. see TCPClient.SetCredentialProvider
+func (ts *TCPServer) SetAuthenticator(authenticator Authenticator) *TCPServer {
+	ts.checkPreparingStatus()
+	ts.authenticator = authenticator
+	return ts
+}
+
+// SetHandshakeTimeout the whole handshake must finish in this time, or the connection closed.
+func (ts *TCPServer) SetHandshakeTimeout(timeout time.Duration) *TCPServer {
+	ts.checkPreparingStatus()
+	ts.handshakeTimeout = timeout
+	return ts
+}
+
+// SetMaxSessions limit the count of connections. 0 means unlimited.
+func (ts *TCPServer) SetMaxSessions(max int) *TCPServer {
+	ts.checkPreparingStatus()
+	ts.maxSessions = max
+	return ts
+}
+
+// SetMaxSessionsPerIP limit the count of connections from one remote IP. 0 means unlimited.
+func (ts *TCPServer) SetMaxSessionsPerIP(max int) *TCPServer {
+	ts.checkPreparingStatus()
+	ts.maxSessionsPerIP = max
+	return ts
+}
+
+// SetLimitPolicy what happens on max sessions limit reached. Default LimitPolicyReject.
+func (ts *TCPServer) SetLimitPolicy(policy LimitPolicy) *TCPServer {
+	ts.checkPreparingStatus()
+	ts.limitPolicy = policy
+	return ts
+}
+
+// SetIPFilter check the remote IP of every new connection.
+// - The rules can be updated through the filter while the server running.
+func (ts *TCPServer) SetIPFilter(filter *IPFilter) *TCPServer {
+	ts.checkPreparingStatus()
+	ts.ipFilter = filter
+	return ts
+}
+
+// IPFilter return the server IP filter, nil if not set
+func (ts *TCPServer) IPFilter() *IPFilter {
+	return ts.ipFilter
+}
+
+// SetWorkerPool run MessageListener on a bounded worker pool instead of the session read goroutine.
+// - Messages of one session are handled by the same worker, in order.
+// - policy: DispatchWait block reading the session when its worker queue is full, DispatchShed drop the message.
+func (ts *TCPServer) SetWorkerPool(workers int, queueSize int, policy DispatchPolicy) *TCPServer {
+	ts.checkPreparingStatus()
+	ts.workers = workers
+	ts.workerQueueSize = queueSize
+	ts.dispatchPolicy = policy
+	return ts
+}
+
+// SetBufferPooling read the packet bodies into size-classed pooled buffers, reused after the message handled.
+// - The body is only valid in Codec.Decode and MessageListener.OnMessage. The default codec copy it.
+// - To keep the body (or a decoded message refer to it) after return, PacketFromContext(ctx).Retain() and Release later.
+// - Carrying the packet in the ctx cost a small allocation per packet, it pays off as the body grows. See BenchmarkPacketRead.
+func (ts *TCPServer) SetBufferPooling(enable bool) *TCPServer {
+	ts.checkPreparingStatus()
+	ts.bufferPooling = enable
+	return ts
+}
+
+// SetEventLoop read all the sessions by a few epoll event loops, instead of a read goroutine per session. Linux only.
+// - Each session keep one goroutine, writing and heartbeat. Same MessageListener and SessionListener API.
+// - The loops decode the packets without blocking, the messages run on the worker pool.
+// - If SetWorkerPool not set, the loops use a pool of NumCPU workers with the default queue size. The setting is not changed.
+// - The loops never wait: RateLimitDelay, or DispatchWait on a full worker queue, pause reading the session only.
+// - The read deadline bound an incomplete packet, as the read goroutine. Idle or half-open sessions are closed by
+// the heartbeat max missed pongs or the idle timeout, not the read deadline, in both modes.
+// - Codec.Decode, ControlHandler, SubscriptionAuthorizer and HeartbeatListener pong events run on the loop goroutine,
+// shared by many sessions: they must be short and never block.
+// - 0 disable (default). Run return error on other OS.
+func (ts *TCPServer) SetEventLoop(loops int) *TCPServer {
+	ts.checkPreparingStatus()
+	ts.eventLoops = loops
+	return ts
+}
+
+// SetReusePort open n listeners on the same address with SO_REUSEPORT, each with its own accept loop. Linux only.
+// - The kernel spread the new connections over the listeners, for the connection storms. Sessions and listeners are shared.
+// - 0 or 1: one listener (default). Run return error on other OS.
+func (ts *TCPServer) SetReusePort(n int) *TCPServer {
+	ts.checkPreparingStatus()
+	ts.reusePort = n
+	return ts
+}
+
+// SetPanicPolicy what happens to the session after a listener/codec panic recovered. Default PanicPolicyCloseSession.
+func (ts *TCPServer) SetPanicPolicy(policy PanicPolicy) *TCPServer {
+	ts.checkPreparingStatus()
+	ts.panicPolicy = policy
+	return ts
+}
+
+// RegisterPanicListener listening panics recovered from listeners, codec and authenticator.
+func (ts *TCPServer) RegisterPanicListener(listener PanicListener) *TCPServer {
+	ts.checkPreparingStatus()
+	ts.panicListener = listener
+	return ts
+}
+
+// SetSubscriptionAuthorizer decide which topic patterns a client can subscribe. Default all allowed.
+func (ts *TCPServer) SetSubscriptionAuthorizer(authorizer SubscriptionAuthorizer) *TCPServer {
+	ts.checkPreparingStatus()
+	ts.subAuthorizer = authorizer
+	return ts
+}
+
+// RegisterPresenceListener listening users online/offline as sessions bind and close. see BindUser
+func (ts *TCPServer) RegisterPresenceListener(listener PresenceListener) *TCPServer {
+	ts.checkPreparingStatus()
+	ts.presenceListener = listener
+	return ts
+}
+
+// RegisterConnectionRejectListener metrics hook on connection rejected before session create.
+func (ts *TCPServer) RegisterConnectionRejectListener(listener ConnectionRejectListener) *TCPServer {
+	ts.checkPreparingStatus()
+	ts.rejectListener = listener
+	return ts
+}
+
+// addSessionObserver add an internal session listener, notified after the registered SessionListener.
+func (ts *TCPServer) addSessionObserver(observer SessionListener) {
+	ts.checkPreparingStatus()
+	ts.sessionObservers = append(ts.sessionObservers, observer)
+}
+
 func (ts *TCPServer) SetDebugMode(on bool) *TCPServer {
 	ts.mu.Lock()
 
@@ -173,6 +408,27 @@ func (ts *TCPServer) SetHeartbeat(heartbeat time.Duration) *TCPServer {
 	return ts
 }
 
+// SetMaxMissedPongs close the session with CloseHeartbeatTimeout if the heartbeat pings sent without pong exceed max.
+// - Default 0, disabled.
+func (ts *TCPServer) SetMaxMissedPongs(max int) *TCPServer {
+	ts.checkPreparingStatus()
+	ts.defaultMaxMissed = max
+	return ts
+}
+
+// RegisterHeartbeatListener listening the heartbeat ping sent, pong received and timeout of sessions.
+func (ts *TCPServer) RegisterHeartbeatListener(listener HeartbeatListener) *TCPServer {
+	ts.checkPreparingStatus()
+	ts.heartbeatListener = listener
+	return ts
+}
+
+func (ts *TCPServer) notifyHeartbeat(s *Session, event HeartbeatEvent) {
+	if ts.heartbeatListener != nil {
+		ts.safeCall(s, "HeartbeatListener.OnHeartbeat", func() { ts.heartbeatListener.OnHeartbeat(s, event) })
+	}
+}
+
 // SetDefaultSessionReadDeadline session timeout if can not read any thing in this time
 func (ts *TCPServer) SetDefaultSessionReadDeadline(read time.Duration) *TCPServer {
 	ts.checkPreparingStatus()
@@ -180,6 +436,20 @@ func (ts *TCPServer) SetDefaultSessionReadDeadline(read time.Duration) *TCPServe
 	return ts
 }
 
+// SetDefaultSessionRateLimit session inbound messages/bytes per second limit. Can be override per session.
+func (ts *TCPServer) SetDefaultSessionRateLimit(limit RateLimit) *TCPServer {
+	ts.checkPreparingStatus()
+	ts.defaultRateLimit = limit
+	return ts
+}
+
+// SetDefaultSessionIdleTimeout close sessions without application message in this time. Heartbeat not counted. 0 disable.
+func (ts *TCPServer) SetDefaultSessionIdleTimeout(idle time.Duration) *TCPServer {
+	ts.checkPreparingStatus()
+	ts.defaultIdleTimeout = idle
+	return ts
+}
+
 func (ts *TCPServer) SetDefaultSessionWriteDeadline(write time.Duration) *TCPServer {
 	ts.checkPreparingStatus()
 	ts.defaultWriteDeadline = write
@@ -198,10 +468,35 @@ func (ts *TCPServer) Run() (*TCPServer, error) {
 		return nil, err
 	}
 
-	ts.listener, err = net.ListenTCP("tcp", tcpAddr)
-	if err != nil {
+	if ts.listeners, err = ts.listen(tcpAddr); err != nil {
 		return nil, err
 	}
+	ts.listener = ts.listeners[0]
+
+	workers := ts.workers
+	if ts.eventLoops > 0 {
+		if ts.reactor, err = newReactor(ts, ts.eventLoops); err != nil {
+			ts.closeListeners()
+			return nil, err
+		}
+		if workers == 0 { // Not run the listeners on the loops
+			workers = runtime.NumCPU()
+		}
+	}
+
+	ts.connLimiter = newConnLimiter(ts.maxSessions, ts.maxSessionsPerIP)
+
+	if workers > 0 {
+		ts.dispatcher = newDispatcher(workers, ts.workerQueueSize, ts.dispatchPolicy)
+		ts.dispatcher.start()
+	}
+
+	ts.timeWheel = newTimeWheel(timeWheelTick, timeWheelSlots, timeWheelLevels)
+	ts.timeWheel.start()
+
+	if ts.reactor != nil {
+		ts.reactor.start()
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 
@@ -209,8 +504,10 @@ func (ts *TCPServer) Run() (*TCPServer, error) {
 	ts.status = Running
 	ts.mu.Unlock()
 
-	// Handle accept
-	go ts.handleAccept(ctx)
+	// Handle accept, a loop per listener
+	for _, listener := range ts.listeners {
+		go ts.handleAccept(ctx, listener)
+	}
 
 	ts.logger.Printf("TCPServer run at %s.", ts.listener.Addr().String())
 
@@ -219,9 +516,18 @@ func (ts *TCPServer) Run() (*TCPServer, error) {
 		<-ts.stopSign
 		cancel()
 
-		err := ts.listener.Close()
-		if err != nil {
-			ts.logger.Print("TCPServer close listen error. ", err)
+		ts.closeListeners()
+
+		for _, s := range ts.Sessions() {
+			s.CloseSessionWithCode(CloseGoingAway, "Server shutting down.")
+		}
+
+		if ts.dispatcher != nil {
+			ts.dispatcher.stop()
+		}
+		ts.timeWheel.stop()
+		if ts.reactor != nil {
+			ts.reactor.stop()
 		}
 
 		ts.logger.Printf("TCPServer stop %s.", ts.listener.Addr().String())
@@ -260,7 +566,43 @@ func (ts *TCPServer) checkHeartbeatLtReadDead() {
 	}
 }
 
-func (ts *TCPServer) handleAccept(ctx context.Context) {
+// listen open the listener, or reusePort listeners on the same address with SO_REUSEPORT.
+// - Port 0 is resolved by the first, the rest listen on the same port.
+func (ts *TCPServer) listen(tcpAddr *net.TCPAddr) ([]*net.TCPListener, error) {
+	if ts.reusePort <= 1 {
+		listener, err := net.ListenTCP("tcp", tcpAddr)
+		if err != nil {
+			return nil, err
+		}
+		return []*net.TCPListener{listener}, nil
+	}
+
+	lc := net.ListenConfig{Control: reusePortControl}
+	addr := tcpAddr.String()
+	listeners := make([]*net.TCPListener, 0, ts.reusePort)
+	for i := 0; i < ts.reusePort; i++ {
+		listener, err := lc.Listen(context.Background(), "tcp", addr)
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, listener.(*net.TCPListener))
+		addr = listener.Addr().String()
+	}
+	return listeners, nil
+}
+
+func (ts *TCPServer) closeListeners() {
+	for _, listener := range ts.listeners {
+		if err := listener.Close(); err != nil {
+			ts.logger.Print("TCPServer close listen error. ", err)
+		}
+	}
+}
+
+func (ts *TCPServer) handleAccept(ctx context.Context, listener *net.TCPListener) {
 
 	for {
 		select {
@@ -270,7 +612,11 @@ func (ts *TCPServer) handleAccept(ctx context.Context) {
 			return
 
 		default:
-			conn, err := ts.listener.AcceptTCP()
+			if ts.limitPolicy == LimitPolicyQueue && !ts.connLimiter.waitSlot(ctx) {
+				continue
+			}
+
+			conn, err := listener.AcceptTCP()
 			if err != nil {
 				if fmt.Sprint(err.(*net.OpError).Err.Error()) == "use of closed network connection" {
 					ts.debugLogger.Print("Accept closed")
@@ -280,6 +626,18 @@ func (ts *TCPServer) handleAccept(ctx context.Context) {
 				continue
 			}
 
+			ip := remoteIP(conn)
+			if ts.ipFilter != nil && !ts.ipFilter.Permitted(net.ParseIP(ip)) {
+				ts.logger.Printf("Connection rejected. client: %s, reason: %s", conn.RemoteAddr().String(), RejectIPDenied)
+				ts.rejectConn(conn, RejectIPDenied)
+				continue
+			}
+			if reason, ok := ts.connLimiter.acquire(ip); !ok {
+				ts.debugLogger.Printf("Connection rejected. client: %s, reason: %s", conn.RemoteAddr().String(), reason)
+				ts.rejectConn(conn, reason)
+				continue
+			}
+
 			go func() {
 				ts.connectHandler.OnConnect(ctx, conn, ts)
 
@@ -288,7 +646,33 @@ func (ts *TCPServer) handleAccept(ctx context.Context) {
 				} else {
 					ts.debugLogger.Print("Conn close. ", conn.RemoteAddr().String())
 				}
+
+				ts.connLimiter.release(ip)
 			}()
 		}
 	}
 }
+
+// rejectConn send the close frame and close the connection before session create, and notify the reject listener.
+func (ts *TCPServer) rejectConn(conn *net.TCPConn, reason RejectReason) {
+	code := CloseTryAgainLater
+	if reason == RejectIPDenied {
+		code = ClosePolicyViolation
+	}
+	if err := writeCloseFrame(conn, CloseFrame{Code: code, Reason: string(reason)}, ts.defaultWriteDeadline); err != nil {
+		ts.debugLogger.Printf("Close frame send error. %v", err)
+	}
+
+	if err := conn.Close(); err != nil {
+		ts.debugLogger.Printf("Conn close error. %v", err)
+	}
+	ts.notifyRejected(conn.RemoteAddr().String(), reason)
+}
+
+func (ts *TCPServer) notifyRejected(remoteAddr string, reason RejectReason) {
+	if ts.rejectListener != nil {
+		ts.safeCall(nil, "ConnectionRejectListener.OnConnectionRejected", func() {
+			ts.rejectListener.OnConnectionRejected(remoteAddr, reason)
+		})
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+//go:build go1.18
+// +build go1.18
+
+package gosocket
+
+import (
+	"context"
+)
+
+// TypedSession a session only send Out messages
+type TypedSession[Out any] struct {
+	*Session
+}
+
+// SendMessage send a typed message to the client
+func (s TypedSession[Out]) SendMessage(message Out) {
+	s.Session.SendMessage(message)
+}
+
+// TypedMessageListener typed message processor interface
+type TypedMessageListener[In, Out any] interface {
+	OnMessage(ctx context.Context, message In, session TypedSession[Out])
+}
+
+// TypedMessageListenerFunc adapt a func to TypedMessageListener
+type TypedMessageListenerFunc[In, Out any] func(ctx context.Context, message In, session TypedSession[Out])
+
+func (f TypedMessageListenerFunc[In, Out]) OnMessage(ctx context.Context, message In, session TypedSession[Out]) {
+	f(ctx, message, session)
+}
+
+// typedMessageListener adapt TypedMessageListener to MessageListener
+type typedMessageListener[In, Out any] struct {
+	listener TypedMessageListener[In, Out]
+}
+
+func (l typedMessageListener[In, Out]) OnMessage(ctx context.Context, message interface{}, session *Session) {
+	m, ok := message.(In)
+	if !ok {
+		session.serRef.debugLogger.Printf("Typed listener message type mismatch. sID: %s, type: %T", session.sID, message)
+		return
+	}
+	l.listener.OnMessage(ctx, m, TypedSession[Out]{Session: session})
+}
+
+// TypedServer type-safe wrapper of TCPServer. In: client -> server message, Out: server -> client message.
+// Usage:
+//
+//	server := NewTypedServer[*Request, *Response]("[::1]:8888", JSONCodec[*Request, *Response]{}).
+//		RegisterMessageListener(TypedMessageListenerFunc[*Request, *Response](
+//			func(ctx context.Context, req *Request, session TypedSession[*Response]) {
+//				session.SendMessage(&Response{ID: req.ID})
+//			}))
+//
+//	// All the other settings on the underlying TCPServer, before Run.
+//	server.Server().SetHeartbeat(10 * time.Second)
+//
+//	_, _ = server.Run()
+type TypedServer[In, Out any] struct {
+	server *TCPServer
+}
+
+// NewTypedServer create a typed tcp server, codec is required.
+func NewTypedServer[In, Out any](addr string, codec TypedCodec[In, Out]) *TypedServer[In, Out] {
+	return &TypedServer[In, Out]{
+		server: NewTCPServer(addr).SetCodec(typedCodec[In, Out]{codec: codec}),
+	}
+}
+
+// Server return the underlying TCPServer
+func (ts *TypedServer[In, Out]) Server() *TCPServer {
+	return ts.server
+}
+
+func (ts *TypedServer[In, Out]) RegisterMessageListener(listener TypedMessageListener[In, Out]) *TypedServer[In, Out] {
+	ts.server.RegisterMessageListener(typedMessageListener[In, Out]{listener: listener})
+	return ts
+}
+
+func (ts *TypedServer[In, Out]) RegisterSessionListener(listener SessionListener) *TypedServer[In, Out] {
+	ts.server.RegisterSessionListener(listener)
+	return ts
+}
+
+func (ts *TypedServer[In, Out]) Run() (*TypedServer[In, Out], error) {
+	if _, err := ts.server.Run(); err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+func (ts *TypedServer[In, Out]) Stop() error {
+	return ts.server.Stop()
+}
+
+// Session return the typed session of sID
+func (ts *TypedServer[In, Out]) Session(sID string) (TypedSession[Out], bool) {
+	s, ok := ts.server.Session(sID)
+	return TypedSession[Out]{Session: s}, ok
+}
@@ -0,0 +1,63 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+//go:build go1.18
+// +build go1.18
+
+package gosocket
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type testTypedRequest struct {
+	ID   int    `json:"id"`
+	Text string `json:"text"`
+}
+
+type testTypedResponse struct {
+	ID    int    `json:"id"`
+	Reply string `json:"reply"`
+}
+
+func TestTypedServerAndClient(t *testing.T) {
+	server := NewTypedServer[*testTypedRequest, *testTypedResponse]("127.0.0.1:18833", JSONCodec[*testTypedRequest, *testTypedResponse]{}).
+		RegisterMessageListener(TypedMessageListenerFunc[*testTypedRequest, *testTypedResponse](
+			func(_ context.Context, req *testTypedRequest, session TypedSession[*testTypedResponse]) {
+				session.SendMessage(&testTypedResponse{ID: req.ID, Reply: "re: " + req.Text})
+			}))
+	server.Server().SetDebugMode(false)
+	if _, err := server.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	replies := make(chan *testTypedResponse, 1)
+
+	client := NewTypedClient[*testTypedResponse, *testTypedRequest]("127.0.0.1:18833", JSONClientCodec[*testTypedResponse, *testTypedRequest]{}).
+		RegisterMessageListener(TypedClientMessageListenerFunc[*testTypedResponse, *testTypedRequest](
+			func(_ context.Context, resp *testTypedResponse, _ *TypedClient[*testTypedResponse, *testTypedRequest]) {
+				replies <- resp
+			}))
+	client.Client().SetDebugMode(false)
+	if _, err := client.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Hangup("Test done.")
+
+	if err := client.SendMessage(&testTypedRequest{ID: 7, Text: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case resp := <-replies:
+		if resp.ID != 7 || resp.Reply != "re: hello" {
+			t.Fatalf("unexpected response %+v", resp)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("no response")
+	}
+}
@@ -5,35 +5,64 @@
 package gosocket
 
 import (
+	"context"
+	"errors"
 	uuid "github.com/satori/go.uuid"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// encodedMessage a message already encoded by the server codec, written as the packet body directly.
+type encodedMessage []byte
+
 type SessionWriter interface {
 	Write()
 }
 
 // ClientSession
 type Session struct {
+	lastActive    int64 // unix nano, atomic. Any packet sent or received. First for 64-bit alignment on 32-bit arch.
+	lastMessage   int64 // unix nano, atomic. Application message only, heartbeat not counted.
 	sID           string
 	status        string
 	attributes    map[string]interface{}
+	identity      interface{}
+	userID        string
 	conn          *net.TCPConn
 	readDeadline  time.Duration
 	writeDeadline time.Duration
 	heartbeat     time.Duration
+	rateLimiter   *rateLimiter
 	writer        *SessionWriter
 	createTime    time.Time
-	lastActive    time.Time
+	idleTimeout   time.Duration
+	closeFrame    CloseFrame // Set on close. The code and reason sent to the client, or received from it.
+	rtt           rttTracker // RTT measured by heartbeat
+	maxMissed     int        // Max heartbeat pings without pong (0: disable)
+	sendClose     bool       // Send the close frame to client on close
 	serRef        *TCPServer
 	closeSign     chan bool
+	closed        chan struct{} // Closed on session close, the senders watch it
 	msgSendChan   chan interface{}
+	ctrlSendChan  chan *Packet
 	mu            sync.Mutex
 }
 
 func NewSession(conn *net.TCPConn, readDeadline time.Duration, WriteDeadline time.Duration, heartbeat time.Duration, serverRef *TCPServer) *Session {
+	var limiter *rateLimiter
+	if serverRef != nil && !serverRef.defaultRateLimit.Unlimited() {
+		limiter = newRateLimiter(serverRef.defaultRateLimit)
+	}
+
+	var idleTimeout time.Duration
+	var maxMissed int
+	if serverRef != nil {
+		idleTimeout = serverRef.defaultIdleTimeout
+		maxMissed = serverRef.defaultMaxMissed
+	}
+
 	return &Session{
 		sID:           uuid.Must(uuid.NewV4()).String(),
 		status:        statusCreated,
@@ -42,11 +71,17 @@ func NewSession(conn *net.TCPConn, readDeadline time.Duration, WriteDeadline tim
 		readDeadline:  readDeadline,
 		writeDeadline: WriteDeadline,
 		heartbeat:     heartbeat,
+		rateLimiter:   limiter,
 		createTime:    time.Now(),
-		lastActive:    time.Now(),
+		lastActive:    time.Now().UnixNano(),
+		lastMessage:   time.Now().UnixNano(),
+		idleTimeout:   idleTimeout,
+		maxMissed:     maxMissed,
 		serRef:        serverRef,
 		closeSign:     make(chan bool, 1),
+		closed:        make(chan struct{}),
 		msgSendChan:   make(chan interface{}, defaultSendChanelCacheSize),
+		ctrlSendChan:  make(chan *Packet, defaultSendChanelCacheSize),
 	}
 }
 
@@ -54,16 +89,58 @@ func (s *Session) SendMessage(message interface{}) {
 	s.msgSendChan <- message
 }
 
+// sendEncoded send a message already encoded by the server codec, without blocking. See trySend.
+func (s *Session) sendEncoded(data []byte) bool {
+	return s.trySend(encodedMessage(data))
+}
+
+// trySend queue the message without blocking, for the fan-out to many sessions.
+// - Return false if the session closed or its send queue is full, a slow session not stall the others.
+func (s *Session) trySend(message interface{}) bool {
+	select {
+	case <-s.closed:
+		return false
+	default:
+	}
+
+	select {
+	case s.msgSendChan <- message:
+		return true
+	default:
+		s.serRef.debugLogger.Printf("Session send queue full, message dropped. sID: %s", s.sID)
+		return false
+	}
+}
+
+// CloseSession close the session with CloseNormal, the client receive the reason.
 func (s *Session) CloseSession(reason string) {
+	s.closeSession(CloseFrame{Code: CloseNormal, Reason: reason}, true)
+}
+
+// CloseSessionWithCode close the session, the client receive the code and reason.
+func (s *Session) CloseSessionWithCode(code CloseCode, reason string) {
+	s.closeSession(CloseFrame{Code: code, Reason: reason}, code != CloseAbnormal)
+}
+
+// Kick close the session with CloseKicked, the client receive the reason.
+func (s *Session) Kick(reason string) {
+	s.closeSession(CloseFrame{Code: CloseKicked, Reason: reason}, true)
+}
+
+// closeSession close the session. sendClose false if the connection is broken or the client closed it.
+func (s *Session) closeSession(frame CloseFrame, sendClose bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	if s.status != statusClosed {
 		s.status = statusClosed
+		s.closeFrame = frame
+		s.sendClose = sendClose
+		close(s.closed)
 		s.closeSign <- true
 		s.serRef.debugLogger.Printf(
-			"Session close. sID: %s, cli: %s, reason: %s",
-			s.sID, s.conn.RemoteAddr().String(), reason)
+			"Session close. sID: %s, cli: %s, code: %d, reason: %s",
+			s.sID, s.conn.RemoteAddr().String(), frame.Code, frame.Reason)
 	}
 }
 
@@ -79,7 +156,12 @@ func (s *Session) SID() string {
 
 // IsClosed return the session is closed
 func (s *Session) IsClosed() bool {
-	return s.status == statusClosed
+	select {
+	case <-s.closed:
+		return true
+	default:
+		return false
+	}
 }
 
 // RemoteAddr return string form of address (for example, "192.0.2.1:25", "[2001:db8::1]:80")
@@ -104,12 +186,70 @@ func (s *Session) SetReadDeadline(readDeadine time.Duration) {
 }
 
 func (s *Session) Heartbeat() time.Duration {
-	return s.readDeadline
+	return s.heartbeat
 }
 func (s *Session) SetHeartbeat(heartbeat time.Duration) {
 	s.heartbeat = heartbeat
 }
 
+// MaxMissedPongs return the max heartbeat pings without pong before close
+func (s *Session) MaxMissedPongs() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.maxMissed
+}
+
+// SetMaxMissedPongs close the session with CloseHeartbeatTimeout if pings without pong exceed max. 0 disable.
+func (s *Session) SetMaxMissedPongs(max int) {
+	s.mu.Lock()
+	s.maxMissed = max
+	s.mu.Unlock()
+}
+
+// Identity return the identity attached by Authenticator. (nil if no authenticator)
+func (s *Session) Identity() interface{} {
+	return s.identity
+}
+
+// SetIdentity attach an identity to the session
+func (s *Session) SetIdentity(identity interface{}) {
+	s.identity = identity
+}
+
+// RateLimit return the session inbound rate limit
+func (s *Session) RateLimit() RateLimit {
+	if limiter := s.inboundLimiter(); limiter != nil {
+		return limiter.limit
+	}
+	return RateLimit{}
+}
+
+// SetRateLimit override the server default inbound rate limit of this session
+func (s *Session) SetRateLimit(limit RateLimit) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.rateLimiter = nil; !limit.Unlimited() {
+		s.rateLimiter = newRateLimiter(limit)
+	}
+}
+
+func (s *Session) inboundLimiter() *rateLimiter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.rateLimiter
+}
+
+// UserID return the user bound to the session, empty if not bound. see TCPServer.BindUser
+func (s *Session) UserID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.userID
+}
+
 // GetAttr get attribute by key
 func (s *Session) Attr(key string) interface{} {
 	return s.attributes[key]
@@ -128,10 +268,67 @@ func (s *Session) CreateTime() time.Time {
 
 // LastActive return the session last active time. (update on create, close, send packet, receive packet)
 func (s *Session) LastActive() time.Time {
-	return s.lastActive
+	return time.Unix(0, atomic.LoadInt64(&s.lastActive))
 }
 
 // UpdateLastActive update the session last active time.
 func (s *Session) UpdateLastActive() {
-	s.lastActive = time.Now()
+	atomic.StoreInt64(&s.lastActive, time.Now().UnixNano())
+}
+
+// RTT return the round-trip time measured by the heartbeat ping/pong
+func (s *Session) RTT() RTTStats {
+	return s.rtt.Stats()
+}
+
+// Ping send a ping and wait the pong, return the round-trip time.
+func (s *Session) Ping(ctx context.Context) (time.Duration, error) {
+	if s.IsClosed() {
+		return 0, errors.New("Session closed. ")
+	}
+	return s.rtt.ping(ctx, s.ctrlSendChan)
+}
+
+// LastMessage return the time of the last application message sent or received. (heartbeat not counted)
+func (s *Session) LastMessage() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&s.lastMessage))
+}
+
+// IdleTime return the time since the last application message
+func (s *Session) IdleTime() time.Duration {
+	return time.Since(s.LastMessage())
+}
+
+func (s *Session) updateLastMessage() {
+	atomic.StoreInt64(&s.lastMessage, time.Now().UnixNano())
+}
+
+// IdleTimeout return the session idle timeout, 0 means never evicted
+func (s *Session) IdleTimeout() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.idleTimeout
+}
+
+// SetIdleTimeout close the session with ReasonIdleTimeout if no application message in this time. 0 disable.
+// - Checked on the heartbeat interval, so the session may live up to one heartbeat longer.
+func (s *Session) SetIdleTimeout(idleTimeout time.Duration) {
+	s.mu.Lock()
+	s.idleTimeout = idleTimeout
+	s.mu.Unlock()
+}
+
+// CloseReason return the reason the session closed, empty if not closed
+func (s *Session) CloseReason() string {
+	return s.CloseFrame().Reason
+}
+
+// CloseFrame return the code and reason the session closed. Zero if not closed.
+// - Closed by the client, it is the frame the client sent. Connection dropped, the code is CloseAbnormal.
+func (s *Session) CloseFrame() CloseFrame {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closeFrame
 }
@@ -0,0 +1,55 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"testing"
+	"time"
+)
+
+type testCloseReasonSessionListener struct {
+	closed chan string
+}
+
+func (t testCloseReasonSessionListener) OnSessionCreate(_ *Session) {}
+
+func (t testCloseReasonSessionListener) OnSessionClose(s *Session) {
+	t.closed <- s.CloseReason()
+}
+
+func TestSessionIdleTimeout(t *testing.T) {
+	closed := make(chan string, 1)
+
+	server, err := NewTCPServer("127.0.0.1:18837").
+		RegisterMessageListener(&TestExampleServerMessageListener{}).
+		RegisterSessionListener(testCloseReasonSessionListener{closed: closed}).
+		SetHeartbeat(100 * time.Millisecond).
+		SetDefaultSessionIdleTimeout(300 * time.Millisecond).
+		SetDebugMode(false).
+		Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	// Server heartbeat keeps the connection active, but no application message.
+	client, err := NewTcpClient("127.0.0.1:18837").
+		RegisterMessageListener(&TestExampleClientListener{}).
+		SetDebugMode(false).
+		Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Hangup("Test done.")
+
+	select {
+	case reason := <-closed:
+		if reason != ReasonIdleTimeout {
+			t.Fatalf("expect close reason %q, got %q", ReasonIdleTimeout, reason)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("idle session not closed")
+	}
+}
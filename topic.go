@@ -0,0 +1,225 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+// Topic pattern wildcards. Topics are '.' separated tokens, like "chat.room.42".
+// - '*' match exactly one token: "chat.*.42" match "chat.room.42"
+// - '>' match one or more tail tokens, only as the last token: "chat.>" match "chat.room.42"
+const (
+	topicSeparator     = "."
+	topicWildcardOne   = "*"
+	topicWildcardTail  = ">"
+	maxTopicPatternLen = 1024
+	maxSessionTopics   = 256 // Max topic patterns a session subscribed
+)
+
+// SubscriptionAuthorizer decide whether a client can subscribe the topic pattern.
+// - Runs on the session read goroutine, or the shared loop goroutine in event loop mode. Keep it short, never block.
+type SubscriptionAuthorizer interface {
+	AuthorizeSubscribe(session *Session, pattern string) bool
+}
+
+// topicRegistry topic pattern subscriptions of the server sessions
+type topicRegistry struct {
+	patterns map[string]map[string]*Session // pattern -> sID -> session
+	sessions map[string]map[string]bool     // sID -> patterns
+	mu       sync.RWMutex
+}
+
+func newTopicRegistry() *topicRegistry {
+	return &topicRegistry{
+		patterns: make(map[string]map[string]*Session),
+		sessions: make(map[string]map[string]bool),
+	}
+}
+
+func (r *topicRegistry) subscribe(s *Session, pattern string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if patterns := r.sessions[s.sID]; len(patterns) >= maxSessionTopics && !patterns[pattern] {
+		return errors.New("Session subscriptions exceed max limit. ")
+	}
+
+	if r.patterns[pattern] == nil {
+		r.patterns[pattern] = make(map[string]*Session)
+	}
+	r.patterns[pattern][s.sID] = s
+
+	if r.sessions[s.sID] == nil {
+		r.sessions[s.sID] = make(map[string]bool)
+	}
+	r.sessions[s.sID][pattern] = true
+	return nil
+}
+
+func (r *topicRegistry) unsubscribe(s *Session, pattern string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.unsubscribeLocked(s.sID, pattern)
+}
+
+func (r *topicRegistry) unsubscribeLocked(sID string, pattern string) {
+	if subs := r.patterns[pattern]; subs != nil {
+		if delete(subs, sID); len(subs) == 0 {
+			delete(r.patterns, pattern)
+		}
+	}
+	if patterns := r.sessions[sID]; patterns != nil {
+		if delete(patterns, pattern); len(patterns) == 0 {
+			delete(r.sessions, sID)
+		}
+	}
+}
+
+// removeSession drop all the subscriptions of the session
+func (r *topicRegistry) removeSession(s *Session) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for pattern := range r.sessions[s.sID] {
+		r.unsubscribeLocked(s.sID, pattern)
+	}
+}
+
+// subscriptions return the patterns the session subscribed
+func (r *topicRegistry) subscriptions(s *Session) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	patterns := make([]string, 0, len(r.sessions[s.sID]))
+	for pattern := range r.sessions[s.sID] {
+		patterns = append(patterns, pattern)
+	}
+	return patterns
+}
+
+// subscribers return the sessions subscribed any pattern matching the topic, each session once.
+func (r *topicRegistry) subscribers(topic string) []*Session {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	matched := make(map[string]*Session)
+	for pattern, subs := range r.patterns {
+		if !topicMatch(pattern, topic) {
+			continue
+		}
+		for sID, s := range subs {
+			matched[sID] = s
+		}
+	}
+
+	sessions := make([]*Session, 0, len(matched))
+	for _, s := range matched {
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+// topicMatch return the topic matches the pattern
+func topicMatch(pattern string, topic string) bool {
+	if pattern == topic {
+		return true
+	}
+
+	pTokens := strings.Split(pattern, topicSeparator)
+	tTokens := strings.Split(topic, topicSeparator)
+
+	for i, p := range pTokens {
+		if p == topicWildcardTail {
+			return len(tTokens) > i
+		}
+		if i >= len(tTokens) || (p != topicWildcardOne && p != tTokens[i]) {
+			return false
+		}
+	}
+	return len(pTokens) == len(tTokens)
+}
+
+// validTopicPattern check the pattern well formed
+func validTopicPattern(pattern string) error {
+	if pattern == "" || len(pattern) > maxTopicPatternLen {
+		return errors.New("Topic pattern empty or too long. ")
+	}
+
+	tokens := strings.Split(pattern, topicSeparator)
+	for i, t := range tokens {
+		if t == "" {
+			return errors.New("Topic pattern has empty token. ")
+		}
+		if t == topicWildcardTail && i != len(tokens)-1 {
+			return errors.New("Topic wildcard '>' must be the last token. ")
+		}
+	}
+	return nil
+}
+
+// Subscribe subscribe the session to the topic pattern on server side.
+// - A session subscribe up to 256 patterns, error if exceeded or the session closed.
+func (ts *TCPServer) Subscribe(s *Session, pattern string) error {
+	if err := validTopicPattern(pattern); err != nil {
+		return err
+	}
+	if err := ts.topics.subscribe(s, pattern); err != nil {
+		return err
+	}
+
+	// Closed meanwhile, the close may have removed the session before the subscribe above. Undo it.
+	if s.IsClosed() {
+		ts.topics.removeSession(s)
+		return errors.New("Session closed. ")
+	}
+	return nil
+}
+
+// Unsubscribe unsubscribe the session from the topic pattern on server side.
+func (ts *TCPServer) Unsubscribe(s *Session, pattern string) {
+	ts.topics.unsubscribe(s, pattern)
+}
+
+// Subscriptions return the topic patterns the session subscribed
+func (ts *TCPServer) Subscriptions(s *Session) []string {
+	return ts.topics.subscriptions(s)
+}
+
+// Publish send the message to every session subscribed a pattern matching the topic.
+// Return the count of sessions the message queued to.
+// - Not block: a subscriber closed or with its send queue full is skipped.
+func (ts *TCPServer) Publish(topic string, message interface{}) int {
+	queued := 0
+	for _, s := range ts.topics.subscribers(topic) {
+		if s.trySend(message) {
+			queued++
+		}
+	}
+	return queued
+}
+
+// onSubscribeCmd client subscribe control packet received
+func (ts *TCPServer) onSubscribeCmd(s *Session, pattern string) {
+	if ts.subAuthorizer != nil {
+		authorized := false
+		ts.safeCall(s, "SubscriptionAuthorizer.AuthorizeSubscribe", func() {
+			authorized = ts.subAuthorizer.AuthorizeSubscribe(s, pattern)
+		})
+		if !authorized {
+			ts.debugLogger.Printf("Subscribe unauthorized. sID: %s, pattern: %s", s.sID, pattern)
+			return
+		}
+	}
+
+	if err := ts.Subscribe(s, pattern); err != nil {
+		ts.debugLogger.Printf("Subscribe failure. sID: %s, pattern: %s, %v", s.sID, pattern, err)
+		return
+	}
+	ts.debugLogger.Printf("Subscribed. sID: %s, pattern: %s", s.sID, pattern)
+}
@@ -0,0 +1,177 @@
+// Copyright 2020 @thiinbit. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package gosocket
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTopicMatch(t *testing.T) {
+	cases := []struct {
+		pattern, topic string
+		want           bool
+	}{
+		{"chat.room", "chat.room", true},
+		{"chat.*", "chat.room", true},
+		{"chat.*", "chat.room.42", false},
+		{"chat.*.42", "chat.room.42", true},
+		{"chat.>", "chat.room.42", true},
+		{"chat.>", "chat", false},
+		{"*", "chat", true},
+		{">", "chat.room", true},
+		{"news.>", "chat.room", false},
+	}
+	for _, c := range cases {
+		if got := topicMatch(c.pattern, c.topic); got != c.want {
+			t.Errorf("topicMatch(%q, %q) expect %v, got %v", c.pattern, c.topic, c.want, got)
+		}
+	}
+
+	for _, bad := range []string{"", "chat..room", "chat.>.room"} {
+		if validTopicPattern(bad) == nil {
+			t.Errorf("expect pattern %q invalid", bad)
+		}
+	}
+}
+
+type testChanClientListener struct {
+	received chan interface{}
+}
+
+func (l testChanClientListener) OnMessage(_ context.Context, message interface{}, _ *TCPClient) {
+	l.received <- message
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	server, err := NewTCPServer("127.0.0.1:18834").
+		RegisterMessageListener(&TestExampleServerMessageListener{}).
+		SetDebugMode(false).
+		Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	subscriber := testChanClientListener{received: make(chan interface{}, 4)}
+	client, err := NewTcpClient("127.0.0.1:18834").
+		RegisterMessageListener(subscriber).
+		SetDebugMode(false).
+		Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Hangup("Test done.")
+
+	other := testChanClientListener{received: make(chan interface{}, 4)}
+	otherClient, err := NewTcpClient("127.0.0.1:18834").
+		RegisterMessageListener(other).
+		SetDebugMode(false).
+		Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer otherClient.Hangup("Test done.")
+
+	_ = client.Subscribe("chat.*")
+	_ = otherClient.Subscribe("news.>")
+
+	// Wait the subscribe control packet arrived.
+	deadline := time.Now().Add(3 * time.Second)
+	for server.Publish("chat.room", "hello room") == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("subscription not arrived")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	select {
+	case m := <-subscriber.received:
+		if m != "hello room" {
+			t.Fatalf("unexpected message %v", m)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("published message not received")
+	}
+
+	select {
+	case m := <-other.received:
+		t.Fatalf("unsubscribed client received %v", m)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestPublishNotBlock(t *testing.T) {
+	server := NewTCPServer("127.0.0.1:0").SetDebugMode(false)
+
+	full := NewSession(nil, 0, 0, 0, server)
+	free := NewSession(nil, 0, 0, 0, server)
+	for i := 0; i < cap(full.msgSendChan); i++ {
+		full.msgSendChan <- i
+	}
+	_ = server.Subscribe(full, "chat.>")
+	_ = server.Subscribe(free, "chat.room")
+
+	done := make(chan int, 1)
+	go func() { done <- server.Publish("chat.room", "hello room") }()
+
+	select {
+	case queued := <-done:
+		if queued != 1 {
+			t.Fatalf("expect queued to 1 session, got %d", queued)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("publish blocked by the full session")
+	}
+	if got := <-free.msgSendChan; got != "hello room" {
+		t.Fatalf("expect hello room, got %v", got)
+	}
+}
+
+func TestSubscribeLimit(t *testing.T) {
+	server := NewTCPServer("127.0.0.1:0").SetDebugMode(false)
+
+	s := NewSession(nil, 0, 0, 0, server)
+	for i := 0; i < maxSessionTopics; i++ {
+		if err := server.Subscribe(s, fmt.Sprintf("chat.%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := server.Subscribe(s, "chat.more"); err == nil {
+		t.Fatal("expect error over the max subscriptions")
+	}
+	if err := server.Subscribe(s, "chat.0"); err != nil {
+		t.Fatalf("expect subscribed pattern not counted again, got %v", err)
+	}
+}
+
+func TestSubscribeClosed(t *testing.T) {
+	server := NewTCPServer("127.0.0.1:0").SetDebugMode(false)
+
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	conn, err := net.DialTCP("tcp", nil, ln.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s := NewSession(conn, 0, 0, 0, server)
+	s.CloseSession("Bye.")
+	server.topics.removeSession(s) // As the session teardown
+
+	if err := server.Subscribe(s, "chat.room"); err == nil {
+		t.Fatal("expect error of the closed session")
+	}
+	if subs := server.topics.subscribers("chat.room"); len(subs) != 0 {
+		t.Fatalf("expect closed session not left subscribed, got %d", len(subs))
+	}
+}